@@ -3,20 +3,28 @@ module github.com/schollz/crawdad/v3
 go 1.12
 
 require (
-	github.com/BurntSushi/toml v0.3.1 // indirect
+	github.com/BurntSushi/toml v0.3.1
+	github.com/andybalholm/brotli v1.0.6
+	github.com/andybalholm/cascadia v1.1.0
+	github.com/antchfx/htmlquery v1.2.3
+	github.com/antchfx/xpath v1.1.6
 	github.com/dustin/go-humanize v1.0.0
 	github.com/go-redis/redis v6.15.2+incompatible
 	github.com/goware/urlx v0.2.0
+	github.com/mattn/go-sqlite3 v1.14.6 // indirect
 	github.com/onsi/ginkgo v1.8.0 // indirect
 	github.com/onsi/gomega v1.5.0 // indirect
 	github.com/pkg/errors v0.8.1
-	github.com/schollz/collectlinks v0.0.0-20170805140755-38870c824643
+	github.com/prometheus/client_golang v1.7.1
+	github.com/saintfish/chardet v0.0.0-20230101081208-5e3ef4b5456d
 	github.com/schollz/logger v1.0.0
 	github.com/schollz/pluck v1.1.3
 	github.com/schollz/progressbar/v2 v2.12.1
-	github.com/sirupsen/logrus v1.4.1 // indirect
-	github.com/stretchr/testify v1.3.0
+	github.com/stretchr/testify v1.4.0
 	github.com/urfave/cli v1.20.0
-	golang.org/x/net v0.0.0-20190509222800-a4d6f7feada5
-	golang.org/x/sys v0.0.0-20190222072716-a9d3bda3a223 // indirect
+	github.com/xitongsys/parquet-go v1.5.2
+	github.com/xitongsys/parquet-go-source v0.0.0-20190524061010-2b72cbee77d5
+	golang.org/x/net v0.0.0-20201021035429-f5854403a974
+	golang.org/x/text v0.3.3
+	modernc.org/sqlite v1.11.2
 )
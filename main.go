@@ -4,11 +4,13 @@ import (
 	"bytes"
 	"encoding/json"
 	"fmt"
+	"io"
 	"io/ioutil"
 	"os"
 	"strings"
 	"time"
 
+	humanize "github.com/dustin/go-humanize"
 	crawdad "github.com/schollz/crawdad/v3/src"
 	"github.com/urfave/cli"
 )
@@ -47,6 +49,21 @@ func main() {
 			Value: "",
 			Usage: "set comma-delimted phrases that must be in URL",
 		},
+		cli.StringFlag{
+			Name:  "allow-types",
+			Value: "",
+			Usage: "set comma-delimited MIME types to download, e.g. 'text/html,text/*'",
+		},
+		cli.StringFlag{
+			Name:  "content-check",
+			Value: "peek",
+			Usage: "check the content type by 'peek' at the response or with a 'head' request first",
+		},
+		cli.StringFlag{
+			Name:  "exclude-ext",
+			Value: "",
+			Usage: "set comma-delimited file extensions to skip, or 'binary' for common binary files",
+		},
 		cli.StringFlag{
 			Name:  "seed",
 			Value: "",
@@ -61,6 +78,64 @@ func main() {
 			Name:  "require-pluck",
 			Usage: "requires that some plucked content is found",
 		},
+		cli.StringFlag{
+			Name:  "fields",
+			Value: "",
+			Usage: "set TOML `file` of fields to extract with CSS selectors or XPath",
+		},
+		cli.BoolFlag{
+			Name:  "structured-data",
+			Usage: "save the JSON-LD, Microdata, RDFa and OpenGraph data of pages",
+		},
+		cli.BoolFlag{
+			Name:  "content",
+			Usage: "save the main text of pages without navigation and other boilerplate",
+		},
+		cli.StringFlag{
+			Name:  "content-selector",
+			Value: "",
+			Usage: "set CSS `selector` of the main content, if it is known",
+		},
+		cli.StringFlag{
+			Name:  "archive",
+			Value: "",
+			Usage: "save every response, compressed, in this `directory`",
+		},
+		cli.StringFlag{
+			Name:  "warc",
+			Value: "",
+			Usage: "write the requests and responses to WARC files in this `directory`",
+		},
+		cli.StringFlag{
+			Name:  "warc-size",
+			Value: "1GB",
+			Usage: "start a new WARC file after this `size`",
+		},
+		cli.StringFlag{
+			Name:  "metrics",
+			Value: "",
+			Usage: "serve Prometheus metrics on this `address` while crawling, e.g. ':9100'",
+		},
+		cli.StringFlag{
+			Name:  "api",
+			Value: "",
+			Usage: "serve the dashboard and JSON control API on this `address` while crawling, e.g. ':8080' for 127.0.0.1:8080",
+		},
+		cli.StringFlag{
+			Name:  "project",
+			Value: "",
+			Usage: "`name` of the crawl in the metrics (default: the host of the base URL)",
+		},
+		cli.StringFlag{
+			Name:  "node",
+			Value: "",
+			Usage: "`name` of this crawdad in the metrics (default: the hostname)",
+		},
+		cli.StringFlag{
+			Name:  "content-remove",
+			Value: "",
+			Usage: "set comma-delimited CSS selectors of boilerplate to remove from the main content",
+		},
 		cli.IntFlag{
 			Name:  "stats",
 			Value: 1,
@@ -84,9 +159,35 @@ func main() {
 			Name:  "debug",
 			Usage: "turn on LOTS of logging",
 		},
-		cli.BoolFlag{
+		cli.StringFlag{
 			Name:  "proxy",
-			Usage: "use tor proxy",
+			Value: "",
+			Usage: "use a proxy `URL` (http://, https:// or socks5://), a file of proxy URLs, or 'tor'",
+		},
+		cli.StringFlag{
+			Name:  "proxy-rotation",
+			Value: "round-robin",
+			Usage: "rotate proxies by 'round-robin' or by 'host'",
+		},
+		cli.StringFlag{
+			Name:  "tor-control",
+			Value: "",
+			Usage: "`address` of the Tor control port to get new circuits when blocked (e.g. 127.0.0.1:9051)",
+		},
+		cli.StringFlag{
+			Name:  "tor-password",
+			Value: "",
+			Usage: "`password` for the Tor control port",
+		},
+		cli.StringFlag{
+			Name:  "tor-cookie",
+			Value: "",
+			Usage: "cookie `file` for the Tor control port",
+		},
+		cli.IntFlag{
+			Name:  "tor-renew",
+			Value: 0,
+			Usage: "get a new Tor circuit every `N` requests",
 		},
 		cli.BoolFlag{
 			Name:  "set",
@@ -111,11 +212,45 @@ func main() {
 			Value: "",
 			Usage: "set the specified `useragent`",
 		},
+		cli.StringFlag{
+			Name:  "useragents",
+			Value: "",
+			Usage: "`file` with user agents to rotate through",
+		},
 		cli.StringFlag{
 			Name:  "cookie",
 			Value: "",
 			Usage: "set the specified `cookie` header",
 		},
+		cli.StringSliceFlag{
+			Name:  "header",
+			Usage: "add a `header` to every request, e.g. 'Accept-Language: en' (can be repeated)",
+		},
+		cli.StringFlag{
+			Name:  "header-profiles",
+			Value: "",
+			Usage: "JSON `file` of named header profiles, e.g. {\"mobile\":{\"User-Agent\":\"...\"}}",
+		},
+		cli.StringFlag{
+			Name:  "auth-basic",
+			Value: "",
+			Usage: "log in with HTTP basic auth as `user:password`",
+		},
+		cli.StringFlag{
+			Name:  "auth-bearer",
+			Value: "",
+			Usage: "log in with a bearer `token`",
+		},
+		cli.StringFlag{
+			Name:  "auth-form",
+			Value: "",
+			Usage: "JSON `file` describing a login form (LoginURL, Fields, SuccessText, ExpiredText)",
+		},
+		cli.StringFlag{
+			Name:  "header-profile",
+			Value: "",
+			Usage: "`name` of the header profile to use",
+		},
 		cli.BoolFlag{
 			Name:  "redo",
 			Usage: "move items from 'doing' to 'todo'",
@@ -132,11 +267,312 @@ func main() {
 			Name:  "no-follow",
 			Usage: "do not follow links (useful with -seed)",
 		},
+		cli.BoolFlag{
+			Name:  "check-links",
+			Usage: "also check links to other sites, without downloading or following them",
+		},
+		cli.BoolFlag{
+			Name:  "sitemaps",
+			Usage: "add the URLs of the sitemaps in robots.txt as seeds",
+		},
+		cli.StringFlag{
+			Name:  "link-sources",
+			Value: "a",
+			Usage: "set comma-delimited kinds of links to follow: " + strings.Join(crawdad.LinkSources, ","),
+		},
+		cli.BoolFlag{
+			Name:  "respect-nofollow",
+			Usage: "do not follow links with rel=\"nofollow\"",
+		},
+		cli.BoolFlag{
+			Name:  "ignore-meta-robots",
+			Usage: "ignore noindex and nofollow in robots meta tags and X-Robots-Tag headers",
+		},
 		cli.IntFlag{
 			Name:  "errors",
 			Value: 10,
 			Usage: "maximum number of errors before exiting",
 		},
+		cli.DurationFlag{
+			Name:  "timeout",
+			Value: 10 * time.Second,
+			Usage: "total time allowed for each request",
+		},
+		cli.DurationFlag{
+			Name:  "connect-timeout",
+			Value: 10 * time.Second,
+			Usage: "time allowed to connect",
+		},
+		cli.DurationFlag{
+			Name:  "tls-timeout",
+			Value: 10 * time.Second,
+			Usage: "time allowed for the TLS handshake",
+		},
+		cli.DurationFlag{
+			Name:  "header-timeout",
+			Value: 10 * time.Second,
+			Usage: "time allowed to wait for the response headers",
+		},
+		cli.IntFlag{
+			Name:  "max-redirects",
+			Value: 10,
+			Usage: "maximum number of redirects to follow",
+		},
+		cli.StringFlag{
+			Name:  "offsite-redirects",
+			Value: "follow",
+			Usage: "'follow' or 'skip' redirects that leave the base URL",
+		},
+		cli.StringFlag{
+			Name:  "ca-bundle",
+			Value: "",
+			Usage: "PEM `file` with extra certificate authorities to trust",
+		},
+		cli.BoolFlag{
+			Name:  "insecure",
+			Usage: "do not verify TLS certificates",
+		},
+		cli.StringFlag{
+			Name:  "max-body-size",
+			Value: "",
+			Usage: "maximum `size` of a response body, e.g. 10MB (bigger ones are skipped)",
+		},
+		cli.BoolFlag{
+			Name:  "truncate",
+			Usage: "truncate bodies bigger than the maximum size instead of skipping them",
+		},
+	}
+
+	app.Commands = []cli.Command{
+		{
+			Name:  "reprocess",
+			Usage: "extract data and links again from archived responses, without fetching anything",
+			Action: func(c *cli.Context) error {
+				craw, err := openCrawler(c)
+				if err != nil {
+					return err
+				}
+				return craw.Reprocess()
+			},
+		},
+		{
+			Name:  "analyze",
+			Usage: "compute the in-degree, out-degree, click depth and PageRank of the done URLs",
+			Flags: []cli.Flag{
+				cli.StringFlag{
+					Name:  "report",
+					Value: "analysis.csv",
+					Usage: "write the report to this `file`, or '-' for stdout",
+				},
+			},
+			Action: func(c *cli.Context) error {
+				if c.String("report") == "-" {
+					// keep stdout for the report
+					crawdad.SetLogOutput(os.Stderr)
+				}
+				craw, err := openCrawler(c)
+				if err != nil {
+					return err
+				}
+				return exportTo(c.String("report"), craw.Analyze)
+			},
+		},
+		{
+			Name:  "sitemap",
+			Usage: "write sitemaps of the done pages that can be indexed",
+			Flags: []cli.Flag{
+				cli.StringFlag{
+					Name:  "dir",
+					Value: "sitemap",
+					Usage: "write the gzipped sitemaps and the sitemap.xml index to this `directory`",
+				},
+				cli.StringFlag{
+					Name:  "sitemap-url",
+					Value: "",
+					Usage: "`URL` the sitemaps will be published under (default: the base URL)",
+				},
+			},
+			Action: func(c *cli.Context) error {
+				craw, err := openCrawler(c)
+				if err != nil {
+					return err
+				}
+				sitemapURL := c.String("sitemap-url")
+				if sitemapURL == "" {
+					sitemapURL = craw.Settings.BaseURL
+				}
+				_, err = craw.WriteSitemaps(c.String("dir"), sitemapURL)
+				return err
+			},
+		},
+		{
+			Name:  "broken-links",
+			Usage: "report the trashed URLs with the pages that link to them",
+			Flags: []cli.Flag{
+				cli.StringFlag{
+					Name:  "report",
+					Value: "-",
+					Usage: "write the report to this `file`, or '-' for stdout",
+				},
+				cli.StringFlag{
+					Name:  "format",
+					Value: "csv",
+					Usage: "write the report as csv, with a row for each referring page, or jsonl",
+				},
+			},
+			Action: func(c *cli.Context) error {
+				if c.String("report") == "-" {
+					// keep stdout for the report
+					crawdad.SetLogOutput(os.Stderr)
+				}
+				craw, err := openCrawler(c)
+				if err != nil {
+					return err
+				}
+				return exportTo(c.String("report"), func(w io.Writer) error {
+					return craw.BrokenLinkReport(w, c.String("format"))
+				})
+			},
+		},
+		{
+			Name:  "export",
+			Usage: "export the done URLs",
+			Flags: []cli.Flag{
+				cli.StringFlag{
+					Name:  "jsonl",
+					Value: "",
+					Usage: "write JSON lines to this `file`, or '-' for stdout",
+				},
+				cli.StringFlag{
+					Name:  "csv",
+					Value: "",
+					Usage: "write CSV to this `file`, or '-' for stdout",
+				},
+				cli.StringFlag{
+					Name:  "parquet",
+					Value: "",
+					Usage: "write Parquet to this `file`, or '-' for stdout",
+				},
+				cli.StringFlag{
+					Name:  "edges",
+					Value: "",
+					Usage: "write the link graph as a CSV edge list to this `file`, or '-' for stdout",
+				},
+				cli.StringFlag{
+					Name:  "graphml",
+					Value: "",
+					Usage: "write the link graph as GraphML to this `file`, or '-' for stdout",
+				},
+				cli.StringFlag{
+					Name:  "dot",
+					Value: "",
+					Usage: "write the link graph as Graphviz DOT to this `file`, or '-' for stdout",
+				},
+				cli.StringFlag{
+					Name:  "sqlite",
+					Value: "",
+					Usage: "write pages, links and failures to this SQLite `file`, updating it if it exists",
+				},
+				cli.StringFlag{
+					Name:  "warc",
+					Value: "",
+					Usage: "write WARC files of the archived responses to this `directory`",
+				},
+				cli.StringFlag{
+					Name:  "columns",
+					Value: strings.Join(crawdad.DefaultExportColumns, ","),
+					Usage: "set comma-delimited CSV columns, nested values like 'extracted.pluck.title' are allowed",
+				},
+				cli.StringFlag{
+					Name:  "state",
+					Value: "done",
+					Usage: "set comma-delimited lists to export: " + strings.Join(crawdad.ExportStates, ","),
+				},
+				cli.StringFlag{
+					Name:  "host",
+					Value: "",
+					Usage: "only export URLs on this host",
+				},
+				cli.StringFlag{
+					Name:  "since",
+					Value: "",
+					Usage: "only export URLs fetched since this `time` (RFC 3339 or YYYY-MM-DD)",
+				},
+				cli.StringFlag{
+					Name:  "until",
+					Value: "",
+					Usage: "only export URLs fetched until this `time` (RFC 3339 or YYYY-MM-DD)",
+				},
+			},
+			Action: func(c *cli.Context) error {
+				formats := map[string]string{}
+				for _, format := range []string{"jsonl", "csv", "parquet"} {
+					if c.String(format) != "" {
+						formats[format] = c.String(format)
+					}
+				}
+				graphFormats := map[string]string{}
+				for flag, format := range map[string]string{"edges": "csv", "graphml": "graphml", "dot": "dot"} {
+					if c.String(flag) != "" {
+						graphFormats[format] = c.String(flag)
+					}
+				}
+				if len(formats) == 0 && len(graphFormats) == 0 && c.String("sqlite") == "" && c.String("warc") == "" {
+					return fmt.Errorf("choose what to export with --jsonl, --csv, --parquet, --edges, --graphml, --dot, --sqlite or --warc")
+				}
+				for _, output := range append(mapValues(formats), mapValues(graphFormats)...) {
+					if output == "-" {
+						// keep stdout for the export
+						crawdad.SetLogOutput(os.Stderr)
+					}
+				}
+				craw, err := openCrawler(c)
+				if err != nil {
+					return err
+				}
+				filter := crawdad.ExportFilter{
+					States: strings.Split(c.String("state"), ","),
+					Host:   c.String("host"),
+				}
+				filter.Since, err = parseTime(c.String("since"))
+				if err != nil {
+					return err
+				}
+				filter.Until, err = parseTime(c.String("until"))
+				if err != nil {
+					return err
+				}
+				columns := strings.Split(c.String("columns"), ",")
+				for format, output := range formats {
+					format := format
+					err = exportTo(output, func(w io.Writer) error {
+						return craw.Export(w, format, filter, columns)
+					})
+					if err != nil {
+						return err
+					}
+				}
+				for format, output := range graphFormats {
+					format := format
+					err = exportTo(output, func(w io.Writer) error {
+						return craw.ExportGraph(w, format, filter)
+					})
+					if err != nil {
+						return err
+					}
+				}
+				if c.String("sqlite") != "" {
+					err = craw.ExportSQLite(c.String("sqlite"), filter)
+					if err != nil {
+						return err
+					}
+				}
+				if c.String("warc") != "" {
+					err = craw.ExportWARC(c.String("warc"), craw.WARCMaxSize)
+				}
+				return err
+			},
+		},
 	}
 
 	app.Action = func(c *cli.Context) error {
@@ -156,57 +592,10 @@ func main() {
                        
 	crawdad version ` + app.Version + "\n\n")
 		// Setup crawler to crawl
-		craw, err := crawdad.New()
-		if err != nil {
-			return err
-		}
-		// set instance options
-		craw.MaxNumberConnections = c.GlobalInt("connections")
-		craw.MaxNumberWorkers = c.GlobalInt("workers")
-		craw.Info = c.GlobalBool("info")
-		if !c.GlobalBool("debug") {
-			crawdad.SetLogLevel("info")
-		}
-		craw.TimeIntervalToPrintStats = c.GlobalInt("stats")
-		craw.UserAgent = c.GlobalString("useragent")
-		craw.Cookie = c.GlobalString("cookie")
-
-		// set public options
-		var options crawdad.Settings
-		if c.GlobalBool("set") {
-			options.BaseURL = c.GlobalString("url")
-			options.AllowQueryParameters = c.GlobalBool("query")
-			options.AllowHashParameters = c.GlobalBool("hash")
-			options.DontFollowLinks = c.GlobalBool("no-follow")
-			options.RequirePluck = c.GlobalBool("require-pluck")
-			if len(c.GlobalString("include")) > 0 {
-				options.KeywordsToInclude = strings.Split(strings.ToLower(c.GlobalString("include")), ",")
-			}
-			if len(c.GlobalString("exclude")) > 0 {
-				options.KeywordsToExclude = strings.Split(strings.ToLower(c.GlobalString("exclude")), ",")
-			}
-			if len(c.GlobalString("pluck")) > 0 {
-				bFile, errFile := ioutil.ReadFile(c.GlobalString("pluck"))
-				if errFile != nil {
-					return errFile
-				}
-				options.PluckConfig = string(bFile)
-			}
-		}
-		craw.UseProxy = c.GlobalBool("proxy")
-		craw.RedisPort = c.GlobalString("port")
-		craw.RedisURL = c.GlobalString("server")
-		craw.MaximumNumberOfErrors = c.GlobalInt("errors")
-		craw.EraseDB = c.GlobalBool("flush")
-		if c.GlobalBool("set") {
-			err = craw.Init(options)
-		} else {
-			err = craw.Init()
-		}
+		craw, err := newCrawler(c)
 		if err != nil {
 			return err
 		}
-
 		if c.GlobalString("seed") != "" {
 			seedData, err := ioutil.ReadFile(c.GlobalString("seed"))
 			if err != nil {
@@ -216,7 +605,7 @@ func main() {
 			for i, seed := range strings.Split(string(seedData), "\n") {
 				seeds[i] = strings.TrimSpace(seed)
 			}
-			err = craw.AddSeeds(seeds)
+			_, err = craw.AddSeeds(seeds)
 			if err != nil {
 				return err
 			}
@@ -254,3 +643,270 @@ func main() {
 		fmt.Print(err)
 	}
 }
+
+// newCrawler sets up a crawler to crawl, queueing the base URL
+func newCrawler(c *cli.Context) (craw *crawdad.Crawler, err error) {
+	craw, settings, err := crawlerFromFlags(c)
+	if err != nil {
+		return
+	}
+	err = craw.Init(settings...)
+	return
+}
+
+// openCrawler connects to the lists of a crawl without setting up crawling or
+// queueing the base URL, for the commands that work on a crawl
+func openCrawler(c *cli.Context) (craw *crawdad.Crawler, err error) {
+	craw, settings, err := crawlerFromFlags(c)
+	if err != nil {
+		return
+	}
+	err = craw.Connect(settings...)
+	return
+}
+
+// crawlerFromFlags makes a crawler with the instance options of the flags,
+// along with the settings to save if they are --set
+func crawlerFromFlags(c *cli.Context) (craw *crawdad.Crawler, settings []crawdad.Settings, err error) {
+	craw, err = crawdad.New()
+	if err != nil {
+		return
+	}
+	// set instance options
+	craw.MaxNumberConnections = c.GlobalInt("connections")
+	craw.MaxNumberWorkers = c.GlobalInt("workers")
+	craw.Info = c.GlobalBool("info")
+	if !c.GlobalBool("debug") {
+		crawdad.SetLogLevel("info")
+	}
+	craw.TimeIntervalToPrintStats = c.GlobalInt("stats")
+	craw.UserAgent = c.GlobalString("useragent")
+	craw.Cookie = c.GlobalString("cookie")
+	craw.HeaderProfile = c.GlobalString("header-profile")
+
+	// set public options
+	var options crawdad.Settings
+	if c.GlobalBool("set") {
+		options.BaseURL = c.GlobalString("url")
+		options.AllowQueryParameters = c.GlobalBool("query")
+		options.AllowHashParameters = c.GlobalBool("hash")
+		options.DontFollowLinks = c.GlobalBool("no-follow")
+		options.CheckLinks = c.GlobalBool("check-links")
+		options.Sitemaps = c.GlobalBool("sitemaps")
+		options.RequirePluck = c.GlobalBool("require-pluck")
+		options.LinkSources = strings.Split(strings.ToLower(c.GlobalString("link-sources")), ",")
+		options.RespectNofollow = c.GlobalBool("respect-nofollow")
+		options.IgnoreMetaRobots = c.GlobalBool("ignore-meta-robots")
+		options.StructuredData = c.GlobalBool("structured-data")
+		options.Content = crawdad.ContentConfig{
+			Enabled:  c.GlobalBool("content") || len(c.GlobalString("content-selector")) > 0,
+			Selector: c.GlobalString("content-selector"),
+		}
+		if len(c.GlobalString("content-remove")) > 0 {
+			options.Content.Remove = strings.Split(c.GlobalString("content-remove"), ",")
+		}
+		if len(c.GlobalString("include")) > 0 {
+			options.KeywordsToInclude = strings.Split(strings.ToLower(c.GlobalString("include")), ",")
+		}
+		if len(c.GlobalString("exclude")) > 0 {
+			options.KeywordsToExclude = strings.Split(strings.ToLower(c.GlobalString("exclude")), ",")
+		}
+		if len(c.GlobalString("allow-types")) > 0 {
+			options.AllowedContentTypes = strings.Split(strings.ToLower(c.GlobalString("allow-types")), ",")
+		}
+		options.ContentTypeCheck = c.GlobalString("content-check")
+		if c.GlobalString("exclude-ext") == "binary" {
+			options.ExcludeExtensions = crawdad.BinaryExtensions
+		} else if len(c.GlobalString("exclude-ext")) > 0 {
+			options.ExcludeExtensions = strings.Split(strings.ToLower(c.GlobalString("exclude-ext")), ",")
+		}
+		if len(c.GlobalString("pluck")) > 0 {
+			bFile, errFile := ioutil.ReadFile(c.GlobalString("pluck"))
+			if errFile != nil {
+				err = errFile
+				return
+			}
+			options.PluckConfig = string(bFile)
+		}
+		if len(c.GlobalString("fields")) > 0 {
+			bFile, errFile := ioutil.ReadFile(c.GlobalString("fields"))
+			if errFile != nil {
+				err = errFile
+				return
+			}
+			options.Fields, err = crawdad.ParseFields(string(bFile))
+			if err != nil {
+				return
+			}
+		}
+		options.Headers, err = parseHeaders(c.GlobalStringSlice("header"))
+		if err != nil {
+			return
+		}
+		if len(c.GlobalString("header-profiles")) > 0 {
+			bFile, errFile := ioutil.ReadFile(c.GlobalString("header-profiles"))
+			if errFile != nil {
+				err = errFile
+				return
+			}
+			err = json.Unmarshal(bFile, &options.HeaderProfiles)
+			if err != nil {
+				return
+			}
+		}
+		options.HeaderProfile = c.GlobalString("header-profile")
+		if len(c.GlobalString("auth-basic")) > 0 {
+			credentials := strings.SplitN(c.GlobalString("auth-basic"), ":", 2)
+			if len(credentials) != 2 {
+				err = fmt.Errorf("basic auth should be like 'user:password'")
+				return
+			}
+			options.Auth = crawdad.AuthConfig{Type: "basic", Username: credentials[0], Password: credentials[1]}
+		} else if len(c.GlobalString("auth-bearer")) > 0 {
+			options.Auth = crawdad.AuthConfig{Type: "bearer", Token: c.GlobalString("auth-bearer")}
+		} else if len(c.GlobalString("auth-form")) > 0 {
+			bFile, errFile := ioutil.ReadFile(c.GlobalString("auth-form"))
+			if errFile != nil {
+				err = errFile
+				return
+			}
+			err = json.Unmarshal(bFile, &options.Auth)
+			if err != nil {
+				return
+			}
+			options.Auth.Type = "form"
+		}
+		if len(c.GlobalString("useragents")) > 0 {
+			options.UserAgents, err = readLines(c.GlobalString("useragents"))
+			if err != nil {
+				return
+			}
+		}
+	}
+	if c.GlobalString("proxy") == "tor" {
+		craw.UseProxy = true
+	} else if c.GlobalString("proxy") != "" {
+		craw.Proxies, err = readProxies(c.GlobalString("proxy"))
+		if err != nil {
+			return
+		}
+	}
+	craw.ProxyRotation = c.GlobalString("proxy-rotation")
+	craw.TorControlAddress = c.GlobalString("tor-control")
+	craw.TorControlPassword = c.GlobalString("tor-password")
+	craw.TorCookieFile = c.GlobalString("tor-cookie")
+	craw.TorRenewEvery = c.GlobalInt("tor-renew")
+	craw.RedisPort = c.GlobalString("port")
+	craw.RedisURL = c.GlobalString("server")
+	craw.MaximumNumberOfErrors = c.GlobalInt("errors")
+	craw.EraseDB = c.GlobalBool("flush")
+	craw.Timeout = c.GlobalDuration("timeout")
+	craw.ConnectTimeout = c.GlobalDuration("connect-timeout")
+	craw.TLSHandshakeTimeout = c.GlobalDuration("tls-timeout")
+	craw.ResponseHeaderTimeout = c.GlobalDuration("header-timeout")
+	craw.MaxRedirects = c.GlobalInt("max-redirects")
+	craw.OffsiteRedirects = c.GlobalString("offsite-redirects")
+	craw.CABundle = c.GlobalString("ca-bundle")
+	craw.Insecure = c.GlobalBool("insecure")
+	if c.GlobalString("max-body-size") != "" {
+		maxBodySize, errParse := humanize.ParseBytes(c.GlobalString("max-body-size"))
+		if errParse != nil {
+			err = errParse
+			return
+		}
+		craw.MaxBodySize = int64(maxBodySize)
+	}
+	craw.TruncateLargeBodies = c.GlobalBool("truncate")
+	craw.ArchiveDir = c.GlobalString("archive")
+	craw.WARCDir = c.GlobalString("warc")
+	warcSize, err := humanize.ParseBytes(c.GlobalString("warc-size"))
+	if err != nil {
+		return
+	}
+	craw.WARCMaxSize = int64(warcSize)
+	craw.MetricsAddress = c.GlobalString("metrics")
+	craw.APIAddress = c.GlobalString("api")
+	craw.Project = c.GlobalString("project")
+	craw.Node = c.GlobalString("node")
+	if c.GlobalBool("set") {
+		settings = []crawdad.Settings{options}
+	}
+	return
+}
+
+// exportTo runs an export to a file, or stdout if it is "-"
+func exportTo(output string, export func(w io.Writer) error) (err error) {
+	if output == "-" {
+		return export(os.Stdout)
+	}
+	f, err := os.Create(output)
+	if err != nil {
+		return
+	}
+	err = export(f)
+	if errClose := f.Close(); err == nil {
+		err = errClose
+	}
+	return
+}
+
+func mapValues(m map[string]string) (values []string) {
+	for _, v := range m {
+		values = append(values, v)
+	}
+	return
+}
+
+// parseTime reads an RFC 3339 time or a date
+func parseTime(s string) (t time.Time, err error) {
+	if s == "" {
+		return
+	}
+	t, err = time.Parse(time.RFC3339, s)
+	if err != nil {
+		t, err = time.Parse("2006-01-02", s)
+	}
+	return
+}
+
+// readProxies returns the proxy URL, or the list of proxy URLs
+// if it is the name of a file
+func readProxies(proxy string) (proxies []string, err error) {
+	if _, errStat := os.Stat(proxy); errStat != nil {
+		return []string{proxy}, nil
+	}
+	return readLines(proxy)
+}
+
+// readLines returns the non-empty lines of a file, skipping # comments
+func readLines(fname string) (lines []string, err error) {
+	data, err := ioutil.ReadFile(fname)
+	if err != nil {
+		return
+	}
+	for _, line := range strings.Split(string(data), "\n") {
+		line = strings.TrimSpace(line)
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		lines = append(lines, line)
+	}
+	return
+}
+
+// parseHeaders parses headers of the form 'Name: value'
+func parseHeaders(headerLines []string) (headers map[string]string, err error) {
+	if len(headerLines) == 0 {
+		return
+	}
+	headers = make(map[string]string)
+	for _, line := range headerLines {
+		parts := strings.SplitN(line, ":", 2)
+		if len(parts) != 2 || strings.TrimSpace(parts[0]) == "" {
+			err = fmt.Errorf("header '%s' should be like 'Name: value'", line)
+			return
+		}
+		headers[strings.TrimSpace(parts[0])] = strings.TrimSpace(parts[1])
+	}
+	return
+}
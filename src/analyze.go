@@ -0,0 +1,261 @@
+package crawdad
+
+import (
+	"encoding/csv"
+	"encoding/json"
+	"io"
+	"math"
+	"net/url"
+	"sort"
+	"strconv"
+
+	"github.com/pkg/errors"
+	log "github.com/schollz/logger"
+)
+
+// seedsKey is the set of seed URLs in the shared database
+const seedsKey = "seeds"
+
+// pageRankDamping is the chance of following a link instead of jumping to a
+// random page
+const pageRankDamping = 0.85
+
+// Analysis is what is known about a done URL from the link graph of the crawl
+type Analysis struct {
+	// InDegree is the number of crawled pages that link to it
+	InDegree int `json:"in_degree"`
+	// OutDegree is the number of URLs it links to
+	OutDegree int `json:"out_degree"`
+	// Depth is the number of clicks from the base URL or the nearest seed, or
+	// -1 if it can't be reached by links
+	Depth    int     `json:"depth"`
+	PageRank float64 `json:"pagerank"`
+	// Orphan is a seed that no crawled page links to
+	Orphan bool `json:"orphan,omitempty"`
+	// DeadEnd is a page that links to no other crawled page
+	DeadEnd bool `json:"dead_end,omitempty"`
+}
+
+// linkGraph is the graph of links between done URLs
+type linkGraph struct {
+	urls  []string
+	index map[string]int
+	// edges are the distinct pages each page links to
+	edges     [][]int
+	outDegree []int
+}
+
+// Analyze computes the in-degree, out-degree, click depth and PageRank of
+// every done URL, saves them in the records and writes a CSV report to w,
+// ordered by PageRank
+func (c *Crawler) Analyze(report io.Writer) (err error) {
+	g, err := c.loadLinkGraph()
+	if err != nil {
+		return
+	}
+	seeds, err := c.shared.SMembers(seedsKey).Result()
+	if err != nil {
+		return
+	}
+	isSeed := make(map[string]bool)
+	for _, seed := range seeds {
+		isSeed[seed] = true
+	}
+	// the base URL is in the lists as it was given, but links to it may be
+	// normalized or end with a slash
+	isBase := make(map[string]bool)
+	roots := seeds
+	for _, base := range baseURLForms(c.Settings.BaseURL) {
+		isBase[base] = true
+		roots = append(roots, base)
+	}
+
+	analyses := make([]Analysis, len(g.urls))
+	for i := range g.urls {
+		analyses[i].OutDegree = g.outDegree[i]
+		analyses[i].DeadEnd = len(g.edges[i]) == 0
+		for _, j := range g.edges[i] {
+			analyses[j].InDegree++
+		}
+	}
+	for i, depth := range g.depths(roots) {
+		analyses[i].Depth = depth
+	}
+	for i, rank := range g.pageRank() {
+		analyses[i].PageRank = rank
+	}
+	var numOrphans, numDeadEnds int
+	for i, url := range g.urls {
+		analyses[i].Orphan = isSeed[url] && analyses[i].InDegree == 0 && !isBase[url]
+		if analyses[i].Orphan {
+			numOrphans++
+		}
+		if analyses[i].DeadEnd {
+			numDeadEnds++
+		}
+	}
+
+	// save the analysis in each record
+	for i, url := range g.urls {
+		var val string
+		val, err = c.done.Get(url).Result()
+		if err != nil {
+			return
+		}
+		var record Record
+		if json.Unmarshal([]byte(val), &record) != nil {
+			record.URL = url
+		}
+		record.Analysis = &analyses[i]
+		b, _ := json.Marshal(record)
+		err = c.done.Set(url, string(b), 0).Err()
+		if err != nil {
+			return
+		}
+	}
+	log.Infof("analyzed %d URLs, found %d orphans and %d dead ends", len(g.urls), numOrphans, numDeadEnds)
+	return writeAnalysisReport(report, g.urls, analyses)
+}
+
+// baseURLForms are the ways a base URL can be written in the lists
+func baseURLForms(base string) (forms []string) {
+	forms = []string{base, normalizeURL(base)}
+	if u, err := url.Parse(base); err == nil && u.Path == "" {
+		u.Path = "/"
+		forms = append(forms, u.String())
+	}
+	return
+}
+
+// loadLinkGraph reads the links of all the done URLs of the site. Links to
+// other sites that were only checked are not part of it.
+func (c *Crawler) loadLinkGraph() (g *linkGraph, err error) {
+	g = &linkGraph{index: make(map[string]int)}
+	links := make(map[int][]Link)
+	err = c.exportRecords(ExportFilter{}, func(r ExportRecord) error {
+		if !c.inScope(r.URL) {
+			return nil
+		}
+		g.index[r.URL] = len(g.urls)
+		links[len(g.urls)] = r.Links
+		g.urls = append(g.urls, r.URL)
+		return nil
+	})
+	if err != nil {
+		err = errors.Wrap(err, "could not read done URLs")
+		return
+	}
+	g.edges = make([][]int, len(g.urls))
+	g.outDegree = make([]int, len(g.urls))
+	for i, url := range g.urls {
+		targets := make(map[string]bool)
+		for _, link := range links[i] {
+			if link.URL == url || targets[link.URL] {
+				continue
+			}
+			targets[link.URL] = true
+			if j, ok := g.index[link.URL]; ok {
+				g.edges[i] = append(g.edges[i], j)
+			}
+		}
+		g.outDegree[i] = len(targets)
+	}
+	return
+}
+
+// depths are the number of clicks from the nearest root to each page, by a
+// breadth first search
+func (g *linkGraph) depths(roots []string) (depths []int) {
+	depths = make([]int, len(g.urls))
+	for i := range depths {
+		depths[i] = -1
+	}
+	var queue []int
+	for _, root := range roots {
+		if start, ok := g.index[root]; ok && depths[start] == -1 {
+			depths[start] = 0
+			queue = append(queue, start)
+		}
+	}
+	for len(queue) > 0 {
+		i := queue[0]
+		queue = queue[1:]
+		for _, j := range g.edges[i] {
+			if depths[j] == -1 {
+				depths[j] = depths[i] + 1
+				queue = append(queue, j)
+			}
+		}
+	}
+	return
+}
+
+// pageRank is computed by power iteration until it changes by less than one
+// part in a million. The rank of dead ends is spread over every page.
+func (g *linkGraph) pageRank() (rank []float64) {
+	n := len(g.urls)
+	if n == 0 {
+		return
+	}
+	rank = make([]float64, n)
+	for i := range rank {
+		rank[i] = 1 / float64(n)
+	}
+	next := make([]float64, n)
+	for iteration := 0; iteration < 100; iteration++ {
+		dangling := 0.0
+		for i := range g.urls {
+			if len(g.edges[i]) == 0 {
+				dangling += rank[i]
+			}
+		}
+		base := (1-pageRankDamping)/float64(n) + pageRankDamping*dangling/float64(n)
+		for i := range next {
+			next[i] = base
+		}
+		for i, targets := range g.edges {
+			share := pageRankDamping * rank[i] / float64(len(targets))
+			for _, j := range targets {
+				next[j] += share
+			}
+		}
+		change := 0.0
+		for i := range rank {
+			change += math.Abs(next[i] - rank[i])
+		}
+		rank, next = next, rank
+		if change < 1e-6 {
+			break
+		}
+	}
+	return
+}
+
+func writeAnalysisReport(w io.Writer, urls []string, analyses []Analysis) (err error) {
+	order := make([]int, len(urls))
+	for i := range order {
+		order[i] = i
+	}
+	sort.SliceStable(order, func(a, b int) bool {
+		if analyses[order[a]].PageRank != analyses[order[b]].PageRank {
+			return analyses[order[a]].PageRank > analyses[order[b]].PageRank
+		}
+		return urls[order[a]] < urls[order[b]]
+	})
+	cw := csv.NewWriter(w)
+	cw.Write([]string{"url", "in_degree", "out_degree", "depth", "pagerank", "orphan", "dead_end"})
+	for _, i := range order {
+		a := analyses[i]
+		cw.Write([]string{
+			urls[i],
+			strconv.Itoa(a.InDegree),
+			strconv.Itoa(a.OutDegree),
+			strconv.Itoa(a.Depth),
+			strconv.FormatFloat(a.PageRank, 'g', 6, 64),
+			strconv.FormatBool(a.Orphan),
+			strconv.FormatBool(a.DeadEnd),
+		})
+	}
+	cw.Flush()
+	return cw.Error()
+}
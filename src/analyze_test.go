@@ -0,0 +1,86 @@
+package crawdad
+
+import (
+	"bytes"
+	"encoding/json"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestAnalyze(t *testing.T) {
+	crawl, err := New()
+	assert.Nil(t, err)
+	crawl.RedisPort = "6377"
+	// the base URL does not have to be normalized like the links
+	err = crawl.Init(Settings{BaseURL: "https://example.com"})
+	assert.Nil(t, err)
+	assert.Nil(t, crawl.Flush())
+
+	// the home page links to a and b, a links back home, b is a dead end and
+	// the seed c is never linked to, but links to d
+	links := map[string][]string{
+		"https://example.com/":  {"https://example.com/a", "https://example.com/b", "https://other.example.com/"},
+		"https://example.com/a": {"https://example.com/", "https://example.com/a"},
+		"https://example.com/b": {"https://other.example.com/"},
+		"https://example.com/c": {"https://example.com/", "https://example.com/d"},
+		"https://example.com/d": {},
+	}
+	for url, targets := range links {
+		record := Record{URL: url, StatusCode: 200}
+		for _, target := range targets {
+			record.Links = append(record.Links, Link{URL: target, Source: "a"})
+		}
+		b, _ := json.Marshal(record)
+		assert.Nil(t, crawl.done.Set(url, string(b), 0).Err())
+	}
+	// links to other sites that were checked are not pages of the site
+	checked, _ := json.Marshal(Record{URL: "https://other.example.com/", StatusCode: 200, Checked: true})
+	assert.Nil(t, crawl.done.Set("https://other.example.com/", string(checked), 0).Err())
+	added, err := crawl.AddSeeds([]string{"https://example.com/c", ""})
+	assert.Nil(t, err)
+	// the seed was crawled already, so only the seeds set learns about it
+	assert.Equal(t, 0, added)
+	assert.Nil(t, crawl.todo.Del("https://example.com/c").Err())
+
+	var report bytes.Buffer
+	assert.Nil(t, crawl.Analyze(&report))
+	analysis := func(url string) Analysis {
+		val, err := crawl.done.Get(url).Result()
+		assert.Nil(t, err)
+		var record Record
+		assert.Nil(t, json.Unmarshal([]byte(val), &record))
+		assert.NotNil(t, record.Analysis)
+		assert.Equal(t, 200, record.StatusCode)
+		return *record.Analysis
+	}
+	home := analysis("https://example.com/")
+	assert.Equal(t, 2, home.InDegree)
+	assert.Equal(t, 3, home.OutDegree)
+	assert.Equal(t, 0, home.Depth)
+	a := analysis("https://example.com/a")
+	assert.Equal(t, 1, a.InDegree)
+	assert.Equal(t, 1, a.OutDegree)
+	assert.Equal(t, 1, a.Depth)
+	assert.False(t, a.DeadEnd)
+	b := analysis("https://example.com/b")
+	assert.True(t, b.DeadEnd)
+	c := analysis("https://example.com/c")
+	assert.True(t, c.Orphan)
+	assert.Equal(t, 0, c.Depth)
+	d := analysis("https://example.com/d")
+	assert.False(t, d.Orphan)
+	assert.Equal(t, 1, d.Depth)
+	assert.True(t, home.PageRank > a.PageRank)
+	assert.InDelta(t, 1, home.PageRank+a.PageRank+b.PageRank+c.PageRank+d.PageRank, 1e-5)
+
+	lines := strings.Split(strings.TrimSpace(report.String()), "\n")
+	assert.Equal(t, 6, len(lines))
+	assert.Equal(t, "url,in_degree,out_degree,depth,pagerank,orphan,dead_end", lines[0])
+	assert.True(t, strings.HasPrefix(lines[1], "https://example.com/,2,3,0,"))
+	val, err := crawl.done.Get("https://other.example.com/").Result()
+	assert.Nil(t, err)
+	assert.NotContains(t, val, "analysis")
+	assert.Nil(t, crawl.Flush())
+}
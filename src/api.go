@@ -0,0 +1,414 @@
+package crawdad
+
+import (
+	"encoding/json"
+	"mime"
+	"net"
+	"net/http"
+	"sync/atomic"
+	"time"
+
+	"github.com/go-redis/redis"
+	"github.com/pkg/errors"
+	log "github.com/schollz/logger"
+)
+
+// keys in the shared database that every node follows while crawling
+const (
+	pausedKey  = "paused"
+	workersKey = "workers"
+)
+
+// Stats are the progress of the crawl, with the lists counted across every
+// node and the rest for the node that answers
+type Stats struct {
+	Todo             int64   `json:"todo"`
+	Doing            int64   `json:"doing"`
+	Done             int64   `json:"done"`
+	Trash            int64   `json:"trash"`
+	Skipped          int64   `json:"skipped"`
+	Paused           bool    `json:"paused"`
+	Workers          int     `json:"workers"`
+	Parsed           int     `json:"parsed"`
+	URLsPerMinute    int     `json:"urls_per_minute"`
+	Errors           int64   `json:"errors"`
+	BytesTransferred int64   `json:"bytes_transferred"`
+	BytesDecoded     int64   `json:"bytes_decoded"`
+	Uptime           float64 `json:"uptime"`
+}
+
+// URLState is which list a URL is in, along with its record
+type URLState struct {
+	URL    string  `json:"url"`
+	State  string  `json:"state"`
+	Record *Record `json:"record,omitempty"`
+}
+
+// Stats counts the lists in Redis
+func (c *Crawler) Stats() (stats Stats, err error) {
+	err = c.updateListCounts()
+	if err != nil {
+		return
+	}
+	stats = Stats{
+		Todo:             c.numToDo,
+		Doing:            c.numDoing,
+		Done:             c.numDone,
+		Trash:            c.numTrash,
+		Skipped:          c.numSkipped,
+		Workers:          c.runningWorkers(),
+		Parsed:           c.numberOfURLSParsed,
+		Errors:           c.errors,
+		BytesTransferred: atomic.LoadInt64(&c.bytesTransferred),
+		BytesDecoded:     atomic.LoadInt64(&c.bytesDecoded),
+	}
+	if !c.programTime.IsZero() {
+		stats.Uptime = time.Since(c.programTime).Seconds()
+		stats.URLsPerMinute = round(60.0 * float64(c.numberOfURLSParsed) / stats.Uptime)
+	}
+	stats.Paused, err = c.Paused()
+	return
+}
+
+// Pause stops every node from taking URLs from todo, until Resume
+func (c *Crawler) Pause() error {
+	return c.shared.Set(pausedKey, "1", 0).Err()
+}
+
+// Resume lets the nodes take URLs from todo again
+func (c *Crawler) Resume() error {
+	return c.shared.Del(pausedKey).Err()
+}
+
+// Paused is true if the crawl is paused
+func (c *Crawler) Paused() (paused bool, err error) {
+	n, err := c.shared.Exists(pausedKey).Result()
+	paused = n > 0
+	return
+}
+
+// SetWorkers changes the number of workers of every node
+func (c *Crawler) SetWorkers(workers int) (err error) {
+	if workers < 1 {
+		return errors.New("need at least one worker")
+	}
+	return c.shared.Set(workersKey, workers, 0).Err()
+}
+
+// sharedWorkers is the number of workers set with SetWorkers, or 0
+func (c *Crawler) sharedWorkers() int {
+	workers, _ := c.shared.Get(workersKey).Int()
+	return workers
+}
+
+// runningWorkers is the number of workers of this node, which the crawl loop
+// changes while the API reads it
+func (c *Crawler) runningWorkers() int {
+	if workers := atomic.LoadInt64(&c.numWorkers); workers > 0 {
+		return int(workers)
+	}
+	return c.MaxNumberWorkers
+}
+
+// SetKeywords changes the keywords that links must or must not have, for
+// every node. Nil leaves them as they are.
+func (c *Crawler) SetKeywords(include, exclude []string) (err error) {
+	c.keywords.Lock()
+	if include != nil {
+		c.Settings.KeywordsToInclude = include
+	}
+	if exclude != nil {
+		c.Settings.KeywordsToExclude = exclude
+	}
+	bSettings, err := json.Marshal(c.Settings)
+	c.keywords.Unlock()
+	if err != nil {
+		return
+	}
+	return c.shared.Set("settings", string(bSettings), 0).Err()
+}
+
+// reloadKeywords picks up keywords that were changed on another node
+func (c *Crawler) reloadKeywords() (err error) {
+	val, err := c.shared.Get("settings").Result()
+	if err != nil {
+		return
+	}
+	var settings Settings
+	err = json.Unmarshal([]byte(val), &settings)
+	if err != nil {
+		return
+	}
+	c.keywords.Lock()
+	c.Settings.KeywordsToInclude = settings.KeywordsToInclude
+	c.Settings.KeywordsToExclude = settings.KeywordsToExclude
+	c.keywords.Unlock()
+	return
+}
+
+// URLState finds which list a URL is in
+func (c *Crawler) URLState(url string) (state URLState, err error) {
+	state.URL = url
+	for _, list := range []struct {
+		name string
+		db   *redis.Client
+	}{
+		{"todo", c.todo},
+		{"doing", c.doing},
+		{"done", c.done},
+		{"trash", c.trash},
+		{"skipped", c.skipped},
+	} {
+		var val string
+		val, err = list.db.Get(url).Result()
+		if err == redis.Nil {
+			continue
+		}
+		if err != nil {
+			return
+		}
+		state.State = list.name
+		if val != "" {
+			state.Record = new(Record)
+			err = json.Unmarshal([]byte(val), state.Record)
+		}
+		return
+	}
+	err = nil
+	state.State = "unknown"
+	return
+}
+
+// RequeueTrash moves the trashed URLs with the reason back to todo, or all of
+// them if the reason is empty
+func (c *Crawler) RequeueTrash(reason string) (requeued int, err error) {
+	var urls []string
+	err = c.exportRecords(ExportFilter{States: []string{"trash"}}, func(r ExportRecord) error {
+		if reason == "" || r.Reason == reason {
+			urls = append(urls, r.URL)
+		}
+		return nil
+	})
+	if err != nil {
+		return
+	}
+	for _, url := range urls {
+		err = c.trash.Del(url).Err()
+		if err != nil {
+			return
+		}
+		err = c.todo.Set(url, "", 0).Err()
+		if err != nil {
+			return
+		}
+		requeued++
+	}
+	log.Infof("requeued %d trashed URLs", requeued)
+	return
+}
+
+// apiHandler serves the JSON API under /api/ and the dashboard
+func (c *Crawler) apiHandler() http.Handler {
+	mux := http.NewServeMux()
+	mux.HandleFunc("/api/stats", c.apiMethods(map[string]apiFunc{
+		"GET": func(r *http.Request) (interface{}, error) {
+			return c.Stats()
+		},
+	}))
+	mux.HandleFunc("/api/seeds", c.apiMethods(map[string]apiFunc{
+		"POST": func(r *http.Request) (interface{}, error) {
+			var body struct {
+				URLs []string `json:"urls"`
+			}
+			if err := json.NewDecoder(r.Body).Decode(&body); err != nil {
+				return nil, apiError{http.StatusBadRequest, err}
+			}
+			added, err := c.AddSeeds(body.URLs)
+			return map[string]int{"added": added}, err
+		},
+	}))
+	mux.HandleFunc("/api/pause", c.apiMethods(map[string]apiFunc{
+		"POST": func(r *http.Request) (interface{}, error) {
+			return map[string]bool{"paused": true}, c.Pause()
+		},
+	}))
+	mux.HandleFunc("/api/resume", c.apiMethods(map[string]apiFunc{
+		"POST": func(r *http.Request) (interface{}, error) {
+			return map[string]bool{"paused": false}, c.Resume()
+		},
+	}))
+	mux.HandleFunc("/api/workers", c.apiMethods(map[string]apiFunc{
+		"GET": func(r *http.Request) (interface{}, error) {
+			workers := c.sharedWorkers()
+			if workers == 0 {
+				workers = c.runningWorkers()
+			}
+			return map[string]int{"workers": workers}, nil
+		},
+		"PUT": func(r *http.Request) (interface{}, error) {
+			var body struct {
+				Workers int `json:"workers"`
+			}
+			if err := json.NewDecoder(r.Body).Decode(&body); err != nil {
+				return nil, apiError{http.StatusBadRequest, err}
+			}
+			if err := c.SetWorkers(body.Workers); err != nil {
+				return nil, apiError{http.StatusBadRequest, err}
+			}
+			return body, nil
+		},
+	}))
+	keywords := func() interface{} {
+		c.keywords.RLock()
+		defer c.keywords.RUnlock()
+		return map[string][]string{
+			"include": c.Settings.KeywordsToInclude,
+			"exclude": c.Settings.KeywordsToExclude,
+		}
+	}
+	mux.HandleFunc("/api/keywords", c.apiMethods(map[string]apiFunc{
+		"GET": func(r *http.Request) (interface{}, error) {
+			if err := c.reloadKeywords(); err != nil {
+				return nil, err
+			}
+			return keywords(), nil
+		},
+		"PUT": func(r *http.Request) (interface{}, error) {
+			var body struct {
+				Include []string `json:"include"`
+				Exclude []string `json:"exclude"`
+			}
+			if err := json.NewDecoder(r.Body).Decode(&body); err != nil {
+				return nil, apiError{http.StatusBadRequest, err}
+			}
+			if err := c.SetKeywords(body.Include, body.Exclude); err != nil {
+				return nil, err
+			}
+			return keywords(), nil
+		},
+	}))
+	mux.HandleFunc("/api/url", c.apiMethods(map[string]apiFunc{
+		"GET": func(r *http.Request) (interface{}, error) {
+			url := r.URL.Query().Get("url")
+			if url == "" {
+				return nil, apiError{http.StatusBadRequest, errors.New("missing url")}
+			}
+			return c.URLState(url)
+		},
+	}))
+	mux.HandleFunc("/api/trash/requeue", c.apiMethods(map[string]apiFunc{
+		"POST": func(r *http.Request) (interface{}, error) {
+			requeued, err := c.RequeueTrash(r.URL.Query().Get("reason"))
+			return map[string]int{"requeued": requeued}, err
+		},
+	}))
+	c.dashboardRoutes(mux)
+	return mux
+}
+
+// apiFunc answers a request with something to write as JSON
+type apiFunc func(r *http.Request) (interface{}, error)
+
+// apiError is an error with the status to answer with
+type apiError struct {
+	status int
+	err    error
+}
+
+func (e apiError) Error() string {
+	return e.err.Error()
+}
+
+// apiMethods routes a request to the function for its method and writes the
+// result, or the error, as JSON
+func (c *Crawler) apiMethods(methods map[string]apiFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		fn, ok := methods[r.Method]
+		if !ok {
+			w.WriteHeader(http.StatusMethodNotAllowed)
+			json.NewEncoder(w).Encode(map[string]string{"error": "method not allowed"})
+			return
+		}
+		// a page on another site can send a simple POST without asking, but
+		// not one with a JSON body, so requests that change the crawl have to
+		// say they are JSON
+		if r.Method != "GET" && r.Method != "HEAD" {
+			mediaType, _, _ := mime.ParseMediaType(r.Header.Get("Content-Type"))
+			if mediaType != "application/json" {
+				w.WriteHeader(http.StatusUnsupportedMediaType)
+				json.NewEncoder(w).Encode(map[string]string{"error": "Content-Type must be application/json"})
+				return
+			}
+		}
+		result, err := fn(r)
+		if err != nil {
+			status := http.StatusInternalServerError
+			if e, ok := err.(apiError); ok {
+				status = e.status
+			}
+			log.Debugf("%s %s: %s", r.Method, r.URL.Path, err.Error())
+			w.WriteHeader(status)
+			json.NewEncoder(w).Encode(map[string]string{"error": err.Error()})
+			return
+		}
+		json.NewEncoder(w).Encode(result)
+	}
+}
+
+// serveHTTP listens on address and serves handler in the background,
+// returning once it is listening
+func serveHTTP(address string, handler http.Handler) (server *http.Server, err error) {
+	listener, err := net.Listen("tcp", address)
+	if err != nil {
+		return
+	}
+	server = &http.Server{Addr: listener.Addr().String(), Handler: handler}
+	go server.Serve(listener)
+	return
+}
+
+// apiListenAddress is the address to serve the API on, which is only this
+// machine if no host is given since the API has no authentication
+func apiListenAddress(address string) string {
+	host, port, err := net.SplitHostPort(address)
+	if err != nil || host != "" {
+		return address
+	}
+	return net.JoinHostPort("127.0.0.1", port)
+}
+
+// serveAPI serves the API while crawling
+func (c *Crawler) serveAPI() (err error) {
+	c.apiServer, err = serveHTTP(apiListenAddress(c.APIAddress), c.apiHandler())
+	if err != nil {
+		err = errors.Wrap(err, "could not listen for the API")
+		return
+	}
+	log.Infof("serving the dashboard at http://%s/ and the API at http://%s/api/", c.apiServer.Addr, c.apiServer.Addr)
+	go c.sampleStats()
+	return
+}
+
+// workersFromAPI starts or stops workers to match the number set with
+// SetWorkers, returning how many are running
+func (c *Crawler) workersFromAPI(running int, jobs chan string) int {
+	workers := c.sharedWorkers()
+	if workers == 0 || workers == running {
+		return running
+	}
+	log.Infof("changing from %d to %d workers", running, workers)
+	for id := running; id < workers; id++ {
+		go c.crawl(id, jobs)
+	}
+	if workers < running {
+		go func(stop int) {
+			for i := 0; i < stop; i++ {
+				c.stopWorker <- struct{}{}
+			}
+		}(running - workers)
+	}
+	atomic.StoreInt64(&c.numWorkers, int64(workers))
+	c.metrics.setWorkers(workers)
+	return workers
+}
@@ -0,0 +1,119 @@
+package crawdad
+
+import (
+	"bytes"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestAPI(t *testing.T) {
+	crawl, err := New()
+	assert.Nil(t, err)
+	crawl.RedisPort = "6377"
+	err = crawl.Init(Settings{BaseURL: "https://example.com"})
+	assert.Nil(t, err)
+	assert.Nil(t, crawl.Flush())
+	server := httptest.NewServer(crawl.apiHandler())
+	defer server.Close()
+
+	call := func(method, path string, body interface{}, v interface{}) int {
+		var b []byte
+		if body != nil {
+			b, _ = json.Marshal(body)
+		}
+		req, err := http.NewRequest(method, server.URL+path, bytes.NewReader(b))
+		assert.Nil(t, err)
+		req.Header.Set("Content-Type", "application/json")
+		resp, err := http.DefaultClient.Do(req)
+		assert.Nil(t, err)
+		defer resp.Body.Close()
+		assert.Equal(t, "application/json", resp.Header.Get("Content-Type"))
+		if v != nil {
+			assert.Nil(t, json.NewDecoder(resp.Body).Decode(v))
+		}
+		return resp.StatusCode
+	}
+
+	// changes have to be sent as JSON, which another site can't do unasked
+	resp, err := http.Post(server.URL+"/api/pause", "text/plain", nil)
+	assert.Nil(t, err)
+	resp.Body.Close()
+	assert.Equal(t, http.StatusUnsupportedMediaType, resp.StatusCode)
+	paused, err := crawl.Paused()
+	assert.Nil(t, err)
+	assert.False(t, paused)
+
+	var added map[string]int
+	assert.Equal(t, 200, call("POST", "/api/seeds", map[string][]string{"urls": {"https://example.com/a", "https://example.com/b"}}, &added))
+	assert.Equal(t, 2, added["added"])
+	assert.Equal(t, 200, call("POST", "/api/seeds", map[string][]string{"urls": {"https://example.com/a", "https://example.com/c"}}, &added))
+	assert.Equal(t, 1, added["added"])
+	var stats Stats
+	assert.Equal(t, 200, call("GET", "/api/stats", nil, &stats))
+	assert.Equal(t, int64(3), stats.Todo)
+	assert.False(t, stats.Paused)
+
+	assert.Equal(t, 200, call("POST", "/api/pause", nil, nil))
+	assert.Equal(t, 200, call("GET", "/api/stats", nil, &stats))
+	assert.True(t, stats.Paused)
+	assert.Equal(t, 200, call("POST", "/api/resume", nil, nil))
+	paused, err = crawl.Paused()
+	assert.Nil(t, err)
+	assert.False(t, paused)
+	assert.Equal(t, 405, call("GET", "/api/pause", nil, nil))
+
+	// workers are changed on every node by the crawl loop
+	var workers map[string]int
+	assert.Equal(t, 400, call("PUT", "/api/workers", map[string]int{"workers": 0}, nil))
+	assert.Equal(t, 200, call("PUT", "/api/workers", map[string]int{"workers": 3}, nil))
+	assert.Equal(t, 200, call("GET", "/api/workers", nil, &workers))
+	assert.Equal(t, 3, workers["workers"])
+	crawl.stopWorker = make(chan struct{})
+	jobs := make(chan string)
+	assert.Equal(t, 3, crawl.workersFromAPI(1, jobs))
+	assert.Equal(t, 3, crawl.workersFromAPI(3, jobs))
+	assert.Nil(t, crawl.SetWorkers(1))
+	assert.Equal(t, 1, crawl.workersFromAPI(3, jobs))
+	assert.Equal(t, 1, crawl.runningWorkers())
+	assert.Equal(t, 8, crawl.MaxNumberWorkers)
+
+	// keywords are saved with the settings for the other nodes
+	var keywords map[string][]string
+	assert.Equal(t, 200, call("PUT", "/api/keywords", map[string][]string{"exclude": {"/private"}}, &keywords))
+	assert.Equal(t, []string{"/private"}, keywords["exclude"])
+	assert.False(t, crawl.allowedKeywords("https://example.com/private/page"))
+	other, err := New()
+	assert.Nil(t, err)
+	other.RedisPort = "6377"
+	assert.Nil(t, other.Init())
+	assert.Equal(t, []string{"/private"}, other.Settings.KeywordsToExclude)
+
+	// trashed URLs can be requeued by their reason
+	crawl.moveToTrash(Record{URL: "https://example.com/gone", StatusCode: 404, Reason: "status"})
+	crawl.moveToTrash(Record{URL: "https://example.com/down", Reason: "error", Error: "connection refused"})
+	var state URLState
+	assert.Equal(t, 200, call("GET", "/api/url?url=https://example.com/gone", nil, &state))
+	assert.Equal(t, "trash", state.State)
+	assert.Equal(t, 404, state.Record.StatusCode)
+	assert.Equal(t, 400, call("GET", "/api/url", nil, nil))
+	var requeued map[string]int
+	assert.Equal(t, 200, call("POST", "/api/trash/requeue?reason=error", nil, &requeued))
+	assert.Equal(t, 1, requeued["requeued"])
+	state = URLState{}
+	assert.Equal(t, 200, call("GET", "/api/url?url=https://example.com/down", nil, &state))
+	assert.Equal(t, "todo", state.State)
+	assert.Nil(t, state.Record)
+	assert.Equal(t, 200, call("GET", "/api/url?url=https://example.com/nowhere", nil, &state))
+	assert.Equal(t, "unknown", state.State)
+	assert.Nil(t, crawl.Flush())
+}
+
+func TestAPIListenAddress(t *testing.T) {
+	assert.Equal(t, "127.0.0.1:8080", apiListenAddress(":8080"))
+	assert.Equal(t, "0.0.0.0:8080", apiListenAddress("0.0.0.0:8080"))
+	assert.Equal(t, "[::1]:8080", apiListenAddress("[::1]:8080"))
+}
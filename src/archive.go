@@ -0,0 +1,215 @@
+package crawdad
+
+import (
+	"bufio"
+	"bytes"
+	"compress/gzip"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/textproto"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+
+	"github.com/pkg/errors"
+	log "github.com/schollz/logger"
+)
+
+// rawResponse is a response as it was received, its status line and headers
+// followed by the body before decompression
+func rawResponse(resp *http.Response, rawBody []byte) []byte {
+	return append([]byte(responseHead(resp)), rawBody...)
+}
+
+// responseHead is the status line and headers of a response
+func responseHead(resp *http.Response) string {
+	var b strings.Builder
+	fmt.Fprintf(&b, "%s %s\r\n", resp.Proto, resp.Status)
+	resp.Header.Write(&b)
+	b.WriteString("\r\n")
+	return b.String()
+}
+
+// archive saves the body of a response as it was received, before
+// decompression. The file is gzipped and named after the SHA-256 of the body,
+// so a body is only stored once. The status line and headers differ on every
+// fetch, so they are kept in the record as ArchiveHead.
+func (c *Crawler) archive(rawBody []byte) (path string, err error) {
+	hash := sha256.Sum256(rawBody)
+	name := hex.EncodeToString(hash[:])
+	path = filepath.Join(c.ArchiveDir, name[:2], name+".gz")
+	if _, errStat := os.Stat(path); errStat == nil {
+		return
+	}
+	err = os.MkdirAll(filepath.Dir(path), 0755)
+	if err != nil {
+		err = errors.Wrap(err, "could not make archive directory")
+		return
+	}
+
+	// write to a temporary file first, so the archive never has partial files
+	f, err := ioutil.TempFile(filepath.Dir(path), name)
+	if err != nil {
+		err = errors.Wrap(err, "could not create archive file")
+		return
+	}
+	gz := gzip.NewWriter(f)
+	_, err = gz.Write(rawBody)
+	if err == nil {
+		err = gz.Close()
+	}
+	if errClose := f.Close(); err == nil {
+		err = errClose
+	}
+	if err == nil {
+		err = os.Rename(f.Name(), path)
+	}
+	if err != nil {
+		os.Remove(f.Name())
+		err = errors.Wrap(err, "could not write archive file")
+	}
+	return
+}
+
+// loadArchive returns the content of a file saved by archive
+func loadArchive(path string) (content []byte, err error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return
+	}
+	defer f.Close()
+	gz, err := gzip.NewReader(f)
+	if err != nil {
+		err = errors.Wrap(err, "could not read "+path)
+		return
+	}
+	defer gz.Close()
+	return ioutil.ReadAll(gz)
+}
+
+// parseArchive splits the content of an archived response
+func parseArchive(content []byte) (statusCode int, header http.Header, rawBody []byte, err error) {
+	r := textproto.NewReader(bufio.NewReader(bytes.NewReader(content)))
+	status, err := r.ReadLine()
+	if err != nil {
+		err = errors.Wrap(err, "could not read status")
+		return
+	}
+	fields := strings.Fields(status)
+	if len(fields) < 2 {
+		err = errors.New("bad status line")
+		return
+	}
+	statusCode, err = strconv.Atoi(fields[1])
+	if err != nil {
+		err = errors.Wrap(err, "bad status line")
+		return
+	}
+	mimeHeader, err := r.ReadMIMEHeader()
+	if err != nil {
+		err = errors.Wrap(err, "could not read headers")
+		return
+	}
+	header = http.Header(mimeHeader)
+	rawBody, err = ioutil.ReadAll(r.R)
+	return
+}
+
+// loadResponse returns the archived response of a record, its status line
+// and headers followed by the raw body
+func loadResponse(record Record) (response []byte, err error) {
+	body, err := loadArchive(record.ArchivePath)
+	if err != nil {
+		return
+	}
+	response = append([]byte(record.ArchiveHead), body...)
+	return
+}
+
+// readArchive reads the archived response of a record
+func readArchive(record Record) (statusCode int, header http.Header, rawBody []byte, err error) {
+	response, err := loadResponse(record)
+	if err != nil {
+		return
+	}
+	statusCode, header, rawBody, err = parseArchive(response)
+	if err != nil {
+		err = errors.Wrap(err, "could not read "+record.ArchivePath)
+	}
+	return
+}
+
+// finalURL is where the redirects of a record ended up
+func finalURL(record Record) string {
+	if len(record.Redirects) > 0 {
+		return record.Redirects[len(record.Redirects)-1]
+	}
+	return record.URL
+}
+
+// Reprocess extracts the data and links of the done URLs again from their
+// archived responses, without fetching anything. Links that were not seen
+// before are added to the todo list.
+func (c *Crawler) Reprocess() (err error) {
+	var numReprocessed, numNotArchived, numFailed int
+	iter := c.done.Scan(0, "*", 1000).Iterator()
+	for iter.Next() {
+		url := iter.Val()
+		var val string
+		val, err = c.done.Get(url).Result()
+		if err != nil {
+			return
+		}
+		var record Record
+		if json.Unmarshal([]byte(val), &record) != nil || record.ArchivePath == "" {
+			numNotArchived++
+			continue
+		}
+		var links []string
+		links, err = c.reprocess(&record)
+		if err != nil {
+			log.Warn(err)
+			numFailed++
+			continue
+		}
+		bRecord, _ := json.Marshal(record)
+		_, err = c.done.Set(url, string(bRecord), 0).Result()
+		if err != nil {
+			return
+		}
+		for _, link := range links {
+			_, err = c.addLinkToDo(link, false)
+			if err != nil {
+				return
+			}
+		}
+		numReprocessed++
+	}
+	err = iter.Err()
+	log.Infof("reprocessed %d URLs, %d were not archived and %d failed", numReprocessed, numNotArchived, numFailed)
+	return
+}
+
+// reprocess updates a record from its archived response
+func (c *Crawler) reprocess(record *Record) (linkCandidates []string, err error) {
+	_, header, rawBody, err := readArchive(*record)
+	if err != nil {
+		err = errors.Wrap(err, "could not read archive of "+record.URL)
+		return
+	}
+	body, _, err := decodeBody(rawBody, header.Get("Content-Encoding"), c.MaxBodySize)
+	if err != nil && !record.Truncated {
+		err = errors.Wrap(err, "could not decode archive of "+record.URL)
+		return
+	}
+	contentType := header.Get("Content-Type")
+	if contentType == "" {
+		contentType = http.DetectContentType(body)
+	}
+	return c.processBody(record, finalURL(*record), header, contentType, body)
+}
@@ -0,0 +1,87 @@
+package crawdad
+
+import (
+	"compress/gzip"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestArchive(t *testing.T) {
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "text/html")
+		w.Header().Set("Content-Encoding", "gzip")
+		gz := gzip.NewWriter(w)
+		fmt.Fprint(gz, `<html><title>Hats</title><a href="/about">about</a><a href="/shop">shop</a></html>`)
+		gz.Close()
+	}))
+	defer ts.Close()
+	dir, err := ioutil.TempDir("", "crawdad")
+	assert.Nil(t, err)
+	defer os.RemoveAll(dir)
+
+	crawl, err := New()
+	assert.Nil(t, err)
+	crawl.RedisURL = "localhost"
+	crawl.RedisPort = "6377"
+	crawl.ArchiveDir = dir
+	err = crawl.Init(Settings{
+		BaseURL:         ts.URL,
+		DontFollowLinks: true,
+	})
+	assert.Nil(t, err)
+	assert.Nil(t, crawl.Flush())
+
+	urls, record, err := crawl.scrapeLinks(ts.URL + "/")
+	assert.Nil(t, err)
+	assert.Empty(t, urls)
+	assert.True(t, strings.HasPrefix(record.ArchivePath, dir))
+
+	// the body is kept as it came, compressed
+	statusCode, header, rawBody, err := readArchive(record)
+	assert.Nil(t, err)
+	assert.Equal(t, 200, statusCode)
+	assert.Equal(t, "gzip", header.Get("Content-Encoding"))
+	assert.Equal(t, record.TransferSize, int64(len(rawBody)))
+
+	// the same body is stored once, with the headers of each fetch
+	_, record2, err := crawl.scrapeLinks(ts.URL + "/")
+	assert.Nil(t, err)
+	assert.Equal(t, record.ArchivePath, record2.ArchivePath)
+	assert.Contains(t, record2.ArchiveHead, "HTTP/1.1 200 OK\r\n")
+	files, _ := filepath.Glob(filepath.Join(dir, "*", "*.gz"))
+	assert.Equal(t, 1, len(files))
+
+	// reprocess with links and plucking turned on
+	bRecord, _ := json.Marshal(record)
+	assert.Nil(t, crawl.done.Set(ts.URL+"/", string(bRecord), 0).Err())
+	ts.Close()
+	crawl, err = New()
+	assert.Nil(t, err)
+	crawl.RedisPort = "6377"
+	err = crawl.Connect(Settings{
+		BaseURL:     ts.URL,
+		PluckConfig: "[[pluck]]\nname = \"title\"\nactivators = [\"<title>\"]\ndeactivator = \"</title>\"",
+	})
+	assert.Nil(t, err)
+	assert.Nil(t, crawl.Reprocess())
+	val, err := crawl.done.Get(ts.URL + "/").Result()
+	assert.Nil(t, err)
+	var reprocessed Record
+	assert.Nil(t, json.Unmarshal([]byte(val), &reprocessed))
+	assert.Equal(t, `{"title":"Hats"}`, string(reprocessed.Extracted["pluck"]))
+	assert.Equal(t, 2, len(reprocessed.Links))
+	_, err = crawl.todo.Get(ts.URL + "/about").Result()
+	assert.Nil(t, err)
+	// connecting does not queue the base URL again
+	assert.Equal(t, int64(0), crawl.todo.Exists(ts.URL).Val())
+	assert.Nil(t, crawl.Flush())
+}
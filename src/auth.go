@@ -0,0 +1,342 @@
+package crawdad
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/cookiejar"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/go-redis/redis"
+	"github.com/pkg/errors"
+	log "github.com/schollz/logger"
+	"golang.org/x/net/html"
+	"golang.org/x/net/publicsuffix"
+)
+
+// loginInterval is the minimum time between two form logins
+const loginInterval = 30 * time.Second
+
+// AuthConfig is how the crawler authenticates to the site being crawled.
+// Type is "basic", "bearer" or "form".
+type AuthConfig struct {
+	Type     string
+	Username string
+	Password string
+	Token    string
+
+	// LoginURL is the page with the login form. The hidden inputs of the
+	// form (e.g. CSRF tokens) are posted along with Fields.
+	LoginURL string
+	Fields   map[string]string
+	// SuccessText must be on the page after logging in, if set
+	SuccessText string
+	// ExpiredText on a page means the session expired, in addition to
+	// getting a 401 or being redirected to the LoginURL
+	ExpiredText string
+}
+
+// String hides the credentials when the settings are logged
+func (a AuthConfig) String() string {
+	if a.Type == "" {
+		return "{}"
+	}
+	return fmt.Sprintf("{%s %s}", a.Type, a.LoginURL)
+}
+
+// redacted is a copy without the password, token and form fields, which
+// JSON encoding would otherwise show
+func (a AuthConfig) redacted() AuthConfig {
+	if a.Password != "" {
+		a.Password = "[redacted]"
+	}
+	if a.Token != "" {
+		a.Token = "[redacted]"
+	}
+	if a.Fields != nil {
+		fields := make(map[string]string, len(a.Fields))
+		for name := range a.Fields {
+			fields[name] = "[redacted]"
+		}
+		a.Fields = fields
+	}
+	return a
+}
+
+// sharedJar is a cookie jar that saves every cookie to Redis so that
+// sessions are shared with the other crawdads
+type sharedJar struct {
+	jar    *cookiejar.Jar
+	shared *redis.Client
+}
+
+type savedCookie struct {
+	URL    string
+	Cookie *http.Cookie
+}
+
+func (j *sharedJar) SetCookies(u *url.URL, cookies []*http.Cookie) {
+	j.jar.SetCookies(u, cookies)
+	for _, cookie := range cookies {
+		field := u.Host + "|" + cookie.Path + "|" + cookie.Name
+		if cookie.MaxAge < 0 {
+			j.shared.HDel("cookies", field).Result()
+			continue
+		}
+		b, err := json.Marshal(savedCookie{URL: u.Scheme + "://" + u.Host, Cookie: cookie})
+		if err != nil {
+			continue
+		}
+		if err = j.shared.HSet("cookies", field, string(b)).Err(); err != nil {
+			log.Warn(errors.Wrap(err, "could not save cookie"))
+		}
+	}
+}
+
+func (j *sharedJar) Cookies(u *url.URL) []*http.Cookie {
+	return j.jar.Cookies(u)
+}
+
+// loadCookies puts the cookies saved by any crawdad into the jar
+func (c *Crawler) loadCookies() (err error) {
+	saved, err := c.shared.HGetAll("cookies").Result()
+	if err != nil {
+		return
+	}
+	for _, val := range saved {
+		var sc savedCookie
+		if json.Unmarshal([]byte(val), &sc) != nil || sc.Cookie == nil {
+			continue
+		}
+		u, errParse := url.Parse(sc.URL)
+		if errParse != nil {
+			continue
+		}
+		c.jar.jar.SetCookies(u, []*http.Cookie{sc.Cookie})
+	}
+	log.Debugf("loaded %d shared cookies", len(saved))
+	return
+}
+
+func (c *Crawler) initAuth() (err error) {
+	jar, err := cookiejar.New(&cookiejar.Options{PublicSuffixList: publicsuffix.List})
+	if err != nil {
+		return
+	}
+	c.jar = &sharedJar{jar: jar, shared: c.shared}
+	c.client.Jar = c.jar
+	err = c.loadCookies()
+	if err != nil {
+		return
+	}
+
+	switch c.Settings.Auth.Type {
+	case "", "basic", "bearer":
+	case "form":
+		if c.Settings.Auth.LoginURL == "" {
+			err = errors.New("form login needs a login URL")
+		}
+	default:
+		err = errors.New("unknown auth type '" + c.Settings.Auth.Type + "', use 'basic', 'bearer' or 'form'")
+	}
+	return
+}
+
+// authorize adds basic or bearer credentials to requests for the site
+func (c *Crawler) authorize(req *http.Request) {
+	if !c.isSameHost(req.URL) {
+		return
+	}
+	switch c.Settings.Auth.Type {
+	case "basic":
+		req.SetBasicAuth(c.Settings.Auth.Username, c.Settings.Auth.Password)
+	case "bearer":
+		req.Header.Set("Authorization", "Bearer "+c.Settings.Auth.Token)
+	}
+}
+
+// isSameHost is true if u is on the host of the base URL, credentials
+// are only ever sent to that host
+func (c *Crawler) isSameHost(u *url.URL) bool {
+	base, err := url.Parse(c.Settings.BaseURL)
+	if err != nil {
+		return false
+	}
+	return strings.EqualFold(base.Host, u.Host)
+}
+
+// sessionExpired checks whether the response to a request for url shows that
+// the login is needed again
+func (c *Crawler) sessionExpired(url string, resp *http.Response, body []byte) bool {
+	if c.Settings.Auth.Type != "form" {
+		return false
+	}
+	if resp.StatusCode == 401 {
+		return true
+	}
+	// the login page itself is crawled like any other page
+	if resp.Request != nil && resp.Request.URL.String() == c.Settings.Auth.LoginURL && url != c.Settings.Auth.LoginURL {
+		return true
+	}
+	return c.Settings.Auth.ExpiredText != "" && bytes.Contains(body, []byte(c.Settings.Auth.ExpiredText))
+}
+
+type loginState struct {
+	lastLogin time.Time
+	sync.Mutex
+}
+
+// login submits the login form, only one crawdad logs in at a time and
+// the others pick up its cookies
+func (c *Crawler) login() (err error) {
+	if c.Settings.Auth.Type != "form" {
+		return
+	}
+	c.loginState.Lock()
+	defer c.loginState.Unlock()
+	if time.Since(c.loginState.lastLogin) < loginInterval {
+		return
+	}
+
+	gotLock, err := c.shared.SetNX("login", "", loginInterval).Result()
+	if err != nil {
+		return
+	}
+	if !gotLock {
+		log.Info("another crawdad is logging in, waiting for its session")
+		time.Sleep(5 * time.Second)
+		return c.loadCookies()
+	}
+	c.loginState.lastLogin = time.Now()
+
+	log.Infof("logging in at %s", c.Settings.Auth.LoginURL)
+	auth := c.Settings.Auth
+	req, err := http.NewRequest("GET", auth.LoginURL, nil)
+	if err != nil {
+		return
+	}
+	c.setHeaders(req)
+	resp, err := c.client.Do(req)
+	if err != nil {
+		return errors.Wrap(err, "could not get login page")
+	}
+	defer resp.Body.Close()
+	body, _, err := readBody(resp.Body, c.MaxBodySize)
+	if err != nil {
+		return
+	}
+	body, _, err = decodeBody(body, resp.Header.Get("Content-Encoding"), c.MaxBodySize)
+	if err != nil {
+		return
+	}
+
+	action, form := parseLoginForm(body)
+	for name, value := range auth.Fields {
+		form.Set(name, value)
+	}
+	actionURL, err := resp.Request.URL.Parse(action)
+	if err != nil {
+		return errors.Wrap(err, "bad login form action")
+	}
+
+	req, err = http.NewRequest("POST", actionURL.String(), strings.NewReader(form.Encode()))
+	if err != nil {
+		return
+	}
+	c.setHeaders(req)
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	resp2, err := c.client.Do(req)
+	if err != nil {
+		return errors.Wrap(err, "could not post login form")
+	}
+	defer resp2.Body.Close()
+	body, _, err = readBody(resp2.Body, c.MaxBodySize)
+	if err != nil {
+		return
+	}
+	body, _, err = decodeBody(body, resp2.Header.Get("Content-Encoding"), c.MaxBodySize)
+	if err != nil {
+		return
+	}
+	if resp2.StatusCode >= 400 {
+		return errors.New(fmt.Sprintf("login failed with code %d", resp2.StatusCode))
+	}
+	if auth.SuccessText != "" && !bytes.Contains(body, []byte(auth.SuccessText)) {
+		return errors.New("login failed, '" + auth.SuccessText + "' not found")
+	}
+	log.Info("logged in")
+	return
+}
+
+// relogin logs in again after the session expired while getting url,
+// the error returned makes the url get retried until it is trashed with
+// the reason "auth"
+func (c *Crawler) relogin(url string) error {
+	if err := c.login(); err != nil {
+		return retryError{"auth", errors.Wrap(err, "session expired for "+url)}
+	}
+	return retryError{"auth", errors.New("session expired for " + url)}
+}
+
+// parseLoginForm finds the form with a password input (or else the first
+// form) and returns its action and the values of its hidden inputs
+func parseLoginForm(body []byte) (action string, values url.Values) {
+	type form struct {
+		action      string
+		values      url.Values
+		hasPassword bool
+	}
+	var forms []*form
+	var current *form
+	z := html.NewTokenizer(bytes.NewReader(body))
+	for {
+		tt := z.Next()
+		if tt == html.ErrorToken {
+			break
+		}
+		token := z.Token()
+		switch {
+		case token.Data == "form" && tt == html.StartTagToken:
+			current = &form{action: getAttr(token, "action"), values: url.Values{}}
+			forms = append(forms, current)
+		case token.Data == "form" && tt == html.EndTagToken:
+			current = nil
+		case token.Data == "input" && current != nil:
+			switch strings.ToLower(getAttr(token, "type")) {
+			case "password":
+				current.hasPassword = true
+			case "hidden":
+				if name := getAttr(token, "name"); name != "" {
+					current.values.Set(name, getAttr(token, "value"))
+				}
+			}
+		}
+	}
+
+	values = url.Values{}
+	if len(forms) == 0 {
+		return
+	}
+	chosen := forms[0]
+	for _, f := range forms {
+		if f.hasPassword {
+			chosen = f
+			break
+		}
+	}
+	return chosen.action, chosen.values
+}
+
+// getAttr returns the value of a tag attribute
+func getAttr(token html.Token, key string) string {
+	for _, attr := range token.Attr {
+		if strings.EqualFold(attr.Key, key) {
+			return attr.Val
+		}
+	}
+	return ""
+}
@@ -0,0 +1,97 @@
+package crawdad
+
+import (
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestParseLoginForm(t *testing.T) {
+	action, values := parseLoginForm([]byte(`<form action="/search"><input type="hidden" name="q" value="x"></form>
+<form action="/session" method="post">
+<input type="hidden" name="csrf" value="abc123">
+<input type="text" name="user">
+<input type="password" name="pass">
+</form>`))
+	assert.Equal(t, "/session", action)
+	assert.Equal(t, "abc123", values.Get("csrf"))
+	assert.Equal(t, "", values.Get("q"))
+}
+
+func TestFormLogin(t *testing.T) {
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/login":
+			if r.Method == "GET" {
+				fmt.Fprint(w, `<form method="post"><input type="hidden" name="csrf" value="token"><input type="password" name="pass"></form>`)
+				return
+			}
+			r.ParseForm()
+			if r.Form.Get("csrf") != "token" || r.Form.Get("pass") != "secret" {
+				w.WriteHeader(403)
+				return
+			}
+			http.SetCookie(w, &http.Cookie{Name: "session", Value: "1", Path: "/"})
+			fmt.Fprint(w, "welcome")
+		case "/private":
+			if _, err := r.Cookie("session"); err != nil {
+				http.Redirect(w, r, "/login", 302)
+				return
+			}
+			fmt.Fprint(w, `<a href="/private/page">page</a>`)
+		case "/expired":
+			w.WriteHeader(401)
+		}
+	}))
+	defer ts.Close()
+
+	crawl, err := New()
+	assert.Nil(t, err)
+	crawl.RedisURL = "localhost"
+	crawl.RedisPort = "6377"
+	crawl.EraseDB = true
+	err = crawl.Init(Settings{
+		BaseURL: ts.URL,
+		Auth: AuthConfig{
+			Type:        "form",
+			LoginURL:    ts.URL + "/login",
+			Fields:      map[string]string{"pass": "secret"},
+			SuccessText: "welcome",
+		},
+	})
+	assert.Nil(t, err)
+	crawl.shared.Del("cookies", "login")
+
+	// not logged in yet, so the session is expired
+	_, _, err = crawl.scrapeLinks(ts.URL + "/private")
+	assert.NotNil(t, err)
+
+	urls, _, err := crawl.scrapeLinks(ts.URL + "/private")
+	assert.Nil(t, err)
+	assert.Equal(t, []string{ts.URL + "/private/page"}, urls)
+
+	// the login page can be crawled
+	_, _, err = crawl.scrapeLinks(ts.URL + "/login")
+	assert.Nil(t, err)
+
+	// a page that keeps asking for the login is trashed after a few attempts
+	for attempt := 1; attempt <= maxAttempts; attempt++ {
+		_, _, err = crawl.scrapeLinks(ts.URL + "/expired")
+		failure, ok := err.(retryError)
+		assert.True(t, ok)
+		assert.Equal(t, attempt < maxAttempts, crawl.retry(ts.URL+"/expired", failure))
+	}
+	val, err := crawl.trash.Get(ts.URL + "/expired").Result()
+	assert.Nil(t, err)
+	assert.Contains(t, val, `"reason":"auth"`)
+
+	// the session cookie is shared with other crawdads
+	cookies, err := crawl.shared.HGetAll("cookies").Result()
+	assert.Nil(t, err)
+	assert.Equal(t, 1, len(cookies))
+	crawl.shared.Del("cookies", "login")
+	assert.Nil(t, crawl.Flush())
+}
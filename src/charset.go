@@ -0,0 +1,111 @@
+package crawdad
+
+import (
+	"bytes"
+	"mime"
+	"strings"
+	"unicode/utf8"
+
+	"github.com/pkg/errors"
+	"github.com/saintfish/chardet"
+	"golang.org/x/net/html"
+	"golang.org/x/net/html/charset"
+)
+
+// detectCharset finds the character set of a body from the Content-Type
+// header, then a <meta> tag in the page, and otherwise by sniffing the bytes
+func detectCharset(body []byte, contentType string) string {
+	if _, params, err := mime.ParseMediaType(contentType); err == nil {
+		if cs, ok := params["charset"]; ok {
+			if _, name := charset.Lookup(cs); name != "" {
+				return name
+			}
+		}
+	}
+	if cs := metaCharset(body); cs != "" {
+		if _, name := charset.Lookup(cs); name != "" {
+			return name
+		}
+	}
+	if utf8.Valid(trimPartialRune(body)) {
+		return "utf-8"
+	}
+	result, err := chardet.NewHtmlDetector().DetectBest(body)
+	if err == nil {
+		if _, name := charset.Lookup(result.Charset); name != "" {
+			return name
+		}
+	}
+	return "windows-1252"
+}
+
+// trimPartialRune drops a rune cut off at the end of a (maybe truncated) body
+func trimPartialRune(body []byte) []byte {
+	for i := len(body) - 1; i >= 0 && i > len(body)-4; i-- {
+		if body[i] < utf8.RuneSelf {
+			break
+		}
+		if utf8.RuneStart(body[i]) {
+			return body[:i]
+		}
+	}
+	return body
+}
+
+// metaCharset returns the charset declared by a <meta> tag in the head
+func metaCharset(body []byte) string {
+	z := html.NewTokenizer(bytes.NewReader(body))
+	for {
+		tt := z.Next()
+		switch tt {
+		case html.ErrorToken:
+			return ""
+		case html.EndTagToken:
+			if name, _ := z.TagName(); string(name) == "head" {
+				return ""
+			}
+		case html.StartTagToken, html.SelfClosingTagToken:
+			token := z.Token()
+			if token.Data == "body" {
+				return ""
+			}
+			if token.Data != "meta" {
+				continue
+			}
+			if cs := getAttr(token, "charset"); cs != "" {
+				return strings.TrimSpace(cs)
+			}
+			if strings.EqualFold(getAttr(token, "http-equiv"), "content-type") {
+				if _, params, err := mime.ParseMediaType(getAttr(token, "content")); err == nil {
+					if cs, ok := params["charset"]; ok {
+						return cs
+					}
+				}
+			}
+		}
+	}
+}
+
+// isText is true for the content types that are converted to UTF-8
+func isText(mediatype string) bool {
+	return mediatype == "" || strings.HasPrefix(mediatype, "text/") ||
+		strings.HasSuffix(mediatype, "xml") || strings.HasSuffix(mediatype, "json") ||
+		mediatype == "application/javascript"
+}
+
+// toUTF8 converts a text body to UTF-8 and returns the charset it was in
+func toUTF8(body []byte, contentType string) (converted []byte, name string, err error) {
+	name = detectCharset(body, contentType)
+	if name == "utf-8" {
+		return body, name, nil
+	}
+	e, _ := charset.Lookup(name)
+	if e == nil {
+		return body, name, nil
+	}
+	converted, err = e.NewDecoder().Bytes(body)
+	if err != nil {
+		err = errors.Wrap(err, "could not convert from "+name)
+	}
+	return
+}
@@ -0,0 +1,34 @@
+package crawdad
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"golang.org/x/text/encoding/charmap"
+	"golang.org/x/text/encoding/japanese"
+)
+
+func TestToUTF8(t *testing.T) {
+	page := `<html><head><meta charset="shift_jis"><title>日本語</title></head><body><a href="/ページ">リンク</a></body></html>`
+	sjis, err := japanese.ShiftJIS.NewEncoder().String(page)
+	assert.Nil(t, err)
+	converted, name, err := toUTF8([]byte(sjis), "text/html")
+	assert.Nil(t, err)
+	assert.Equal(t, "shift_jis", name)
+	assert.Equal(t, page, string(converted))
+
+	// the header wins over the meta tag
+	page = `<html><head><meta http-equiv="Content-Type" content="text/html; charset=utf-8"></head><body>café</body></html>`
+	latin, err := charmap.Windows1252.NewEncoder().String(page)
+	assert.Nil(t, err)
+	converted, name, err = toUTF8([]byte(latin), "text/html; charset=windows-1252")
+	assert.Nil(t, err)
+	assert.Equal(t, "windows-1252", name)
+	assert.Equal(t, page, string(converted))
+
+	// sniffed
+	converted, name, err = toUTF8([]byte("<p>naïve</p>"), "text/html")
+	assert.Nil(t, err)
+	assert.Equal(t, "utf-8", name)
+	assert.Equal(t, "<p>naïve</p>", string(converted))
+}
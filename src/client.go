@@ -0,0 +1,108 @@
+package crawdad
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/pkg/errors"
+)
+
+// newClient builds the HTTP client from the timeout, redirect and TLS options
+func (c *Crawler) newClient() (client *http.Client, err error) {
+	tlsConfig := &tls.Config{
+		InsecureSkipVerify: c.Insecure,
+	}
+	if c.CABundle != "" {
+		var pem []byte
+		pem, err = ioutil.ReadFile(c.CABundle)
+		if err != nil {
+			err = errors.Wrap(err, "could not read CA bundle")
+			return
+		}
+		tlsConfig.RootCAs, err = x509.SystemCertPool()
+		if err != nil || tlsConfig.RootCAs == nil {
+			tlsConfig.RootCAs = x509.NewCertPool()
+		}
+		if !tlsConfig.RootCAs.AppendCertsFromPEM(pem) {
+			err = errors.New("no certificates found in " + c.CABundle)
+			return
+		}
+	}
+
+	// compression is handled by decodeBody
+	tr := &http.Transport{
+		Proxy: c.proxyForRequest,
+		DialContext: (&net.Dialer{
+			Timeout:   c.ConnectTimeout,
+			KeepAlive: 30 * time.Second,
+		}).DialContext,
+		TLSClientConfig:       tlsConfig,
+		TLSHandshakeTimeout:   c.TLSHandshakeTimeout,
+		ResponseHeaderTimeout: c.ResponseHeaderTimeout,
+		MaxIdleConns:          c.MaxNumberConnections,
+		IdleConnTimeout:       15 * time.Second,
+		DisableCompression:    true,
+	}
+	client = &http.Client{
+		Transport:     tr,
+		Timeout:       c.Timeout,
+		CheckRedirect: c.checkRedirect,
+	}
+	switch c.OffsiteRedirects {
+	case "", "follow", "skip":
+	default:
+		err = errors.New("unknown offsite redirect policy '" + c.OffsiteRedirects + "', use 'follow' or 'skip'")
+	}
+	return
+}
+
+// checkRedirect limits the number of redirects and, if asked, stops at
+// redirects that leave the base URL. With no redirects allowed, the redirect
+// itself is the response.
+func (c *Crawler) checkRedirect(req *http.Request, via []*http.Request) error {
+	if c.MaxRedirects == 0 {
+		return http.ErrUseLastResponse
+	}
+	if len(via) > c.MaxRedirects {
+		return errors.New(fmt.Sprintf("stopped after %d redirects", c.MaxRedirects))
+	}
+	if c.OffsiteRedirects == "skip" && !c.inScope(req.URL.String()) {
+		return http.ErrUseLastResponse
+	}
+	return nil
+}
+
+// redirectChain lists the URLs that were redirected to, in order
+func redirectChain(resp *http.Response) (chain []string) {
+	for r := resp.Request; r != nil && r.Response != nil; r = r.Response.Request {
+		chain = append([]string{r.URL.String()}, chain...)
+	}
+	return
+}
+
+// inScope is true if the link is under the base URL
+func (c *Crawler) inScope(link string) bool {
+	return strings.Contains(link, c.Settings.BaseURL)
+}
+
+// readBody reads at most maxSize bytes of a body (all of it, if maxSize is 0)
+// and reports whether there was more
+func readBody(r io.Reader, maxSize int64) (body []byte, truncated bool, err error) {
+	if maxSize <= 0 {
+		body, err = ioutil.ReadAll(r)
+		return
+	}
+	body, err = ioutil.ReadAll(io.LimitReader(r, maxSize+1))
+	if int64(len(body)) > maxSize {
+		body = body[:maxSize]
+		truncated = true
+	}
+	return
+}
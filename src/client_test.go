@@ -0,0 +1,79 @@
+package crawdad
+
+import (
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestClientRedirects(t *testing.T) {
+	offsite := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		fmt.Fprint(w, "offsite")
+	}))
+	defer offsite.Close()
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/a":
+			http.Redirect(w, r, "/b", 301)
+		case "/b":
+			http.Redirect(w, r, "/c", 302)
+		case "/away":
+			http.Redirect(w, r, offsite.URL, 302)
+		default:
+			fmt.Fprint(w, "ok")
+		}
+	}))
+	defer ts.Close()
+
+	c, err := New()
+	assert.Nil(t, err)
+	c.Settings.BaseURL = ts.URL
+	c.client, err = c.newClient()
+	assert.Nil(t, err)
+
+	resp, err := c.client.Get(ts.URL + "/a")
+	assert.Nil(t, err)
+	assert.Equal(t, []string{ts.URL + "/b", ts.URL + "/c"}, redirectChain(resp))
+
+	c.MaxRedirects = 1
+	_, err = c.client.Get(ts.URL + "/a")
+	assert.NotNil(t, err)
+	c.MaxRedirects = 0
+	resp, err = c.client.Get(ts.URL + "/a")
+	assert.Nil(t, err)
+	assert.Equal(t, 301, resp.StatusCode)
+	c.MaxRedirects = 1
+
+	resp, err = c.client.Get(ts.URL + "/away")
+	assert.Nil(t, err)
+	assert.Equal(t, 200, resp.StatusCode)
+	c.OffsiteRedirects = "skip"
+	resp, err = c.client.Get(ts.URL + "/away")
+	assert.Nil(t, err)
+	assert.Equal(t, 302, resp.StatusCode)
+
+	c.OffsiteRedirects = "sometimes"
+	_, err = c.newClient()
+	assert.NotNil(t, err)
+}
+
+func TestReadBody(t *testing.T) {
+	body, truncated, err := readBody(strings.NewReader("0123456789"), 0)
+	assert.Nil(t, err)
+	assert.False(t, truncated)
+	assert.Equal(t, "0123456789", string(body))
+
+	body, truncated, err = readBody(strings.NewReader("0123456789"), 10)
+	assert.Nil(t, err)
+	assert.False(t, truncated)
+	assert.Equal(t, "0123456789", string(body))
+
+	body, truncated, err = readBody(strings.NewReader("0123456789"), 4)
+	assert.Nil(t, err)
+	assert.True(t, truncated)
+	assert.Equal(t, "0123", string(body))
+}
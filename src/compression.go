@@ -0,0 +1,57 @@
+package crawdad
+
+import (
+	"bytes"
+	"compress/flate"
+	"compress/gzip"
+	"compress/zlib"
+	"strings"
+
+	"github.com/andybalholm/brotli"
+	"github.com/pkg/errors"
+)
+
+// acceptEncoding is sent with every request. The transport is built with
+// compression disabled so that the bodies are decoded here instead, which
+// lets the crawler count the bytes that actually went over the wire.
+const acceptEncoding = "gzip, deflate, br"
+
+// decodeBody decompresses a body according to its Content-Encoding header.
+// Multiple encodings are undone in the reverse order that they were applied.
+// The decoded body is cut at maxSize bytes, unless maxSize is 0.
+func decodeBody(body []byte, contentEncoding string, maxSize int64) (decoded []byte, truncated bool, err error) {
+	decoded = body
+	encodings := strings.Split(contentEncoding, ",")
+	for i := len(encodings) - 1; i >= 0; i-- {
+		encoding := strings.ToLower(strings.TrimSpace(encodings[i]))
+		switch encoding {
+		case "", "identity":
+			continue
+		case "gzip", "x-gzip":
+			var r *gzip.Reader
+			r, err = gzip.NewReader(bytes.NewReader(decoded))
+			if err != nil {
+				return
+			}
+			decoded, truncated, err = readBody(r, maxSize)
+		case "deflate":
+			// "deflate" should be zlib-wrapped, but plenty of servers send
+			// raw deflate data so fallback to that
+			r, errZlib := zlib.NewReader(bytes.NewReader(decoded))
+			if errZlib == nil {
+				decoded, truncated, err = readBody(r, maxSize)
+			} else {
+				decoded, truncated, err = readBody(flate.NewReader(bytes.NewReader(decoded)), maxSize)
+			}
+		case "br":
+			decoded, truncated, err = readBody(brotli.NewReader(bytes.NewReader(decoded)), maxSize)
+		default:
+			err = errors.New("unsupported content encoding '" + encoding + "'")
+		}
+		if err != nil {
+			err = errors.Wrap(err, "could not decode "+encoding)
+			return
+		}
+	}
+	return
+}
@@ -0,0 +1,70 @@
+package crawdad
+
+import (
+	"bytes"
+	"compress/flate"
+	"compress/gzip"
+	"compress/zlib"
+	"testing"
+
+	"github.com/andybalholm/brotli"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestDecodeBody(t *testing.T) {
+	body := []byte("<html><a href='/about'>about</a></html>")
+
+	var gz bytes.Buffer
+	w := gzip.NewWriter(&gz)
+	w.Write(body)
+	w.Close()
+	decoded, _, err := decodeBody(gz.Bytes(), "gzip", 0)
+	assert.Nil(t, err)
+	assert.Equal(t, body, decoded)
+
+	var zl bytes.Buffer
+	wz := zlib.NewWriter(&zl)
+	wz.Write(body)
+	wz.Close()
+	decoded, _, err = decodeBody(zl.Bytes(), "deflate", 0)
+	assert.Nil(t, err)
+	assert.Equal(t, body, decoded)
+
+	var fl bytes.Buffer
+	wf, _ := flate.NewWriter(&fl, flate.DefaultCompression)
+	wf.Write(body)
+	wf.Close()
+	decoded, _, err = decodeBody(fl.Bytes(), "deflate", 0)
+	assert.Nil(t, err)
+	assert.Equal(t, body, decoded)
+
+	var br bytes.Buffer
+	wb := brotli.NewWriter(&br)
+	wb.Write(body)
+	wb.Close()
+	decoded, _, err = decodeBody(br.Bytes(), "br", 0)
+	assert.Nil(t, err)
+	assert.Equal(t, body, decoded)
+
+	// gzip applied on top of brotli
+	var both bytes.Buffer
+	w = gzip.NewWriter(&both)
+	w.Write(br.Bytes())
+	w.Close()
+	decoded, _, err = decodeBody(both.Bytes(), "br, gzip", 0)
+	assert.Nil(t, err)
+	assert.Equal(t, body, decoded)
+
+	decoded, _, err = decodeBody(body, "", 0)
+	assert.Nil(t, err)
+	assert.Equal(t, body, decoded)
+
+	_, _, err = decodeBody(body, "compress", 0)
+	assert.NotNil(t, err)
+
+	// decoded bodies are limited too
+	decoded, truncated, err := decodeBody(gz.Bytes(), "gzip", 10)
+	assert.Nil(t, err)
+	assert.True(t, truncated)
+	assert.Equal(t, body[:10], decoded)
+}
@@ -0,0 +1,116 @@
+package crawdad
+
+import (
+	"bytes"
+	"io"
+	"mime"
+	"net/http"
+	"net/url"
+	"path"
+	"strings"
+
+	log "github.com/schollz/logger"
+)
+
+// BinaryExtensions are file extensions of assets that are rarely worth
+// downloading when crawling for pages
+var BinaryExtensions = []string{
+	"7z", "avi", "bmp", "bz2", "dmg", "doc", "docx", "exe", "flac", "gif",
+	"gz", "ico", "iso", "jpeg", "jpg", "m4a", "mkv", "mov", "mp3", "mp4",
+	"mpeg", "ogg", "pdf", "png", "ppt", "pptx", "rar", "svg", "tar", "tgz",
+	"tif", "tiff", "wav", "webm", "webp", "woff", "woff2", "xls", "xlsx", "zip",
+}
+
+// mediaType returns the lowercase MIME type of a Content-Type header
+func mediaType(contentType string) string {
+	mediatype, _, err := mime.ParseMediaType(contentType)
+	if err != nil {
+		return strings.ToLower(strings.TrimSpace(strings.Split(contentType, ";")[0]))
+	}
+	return mediatype
+}
+
+// allowedContentType checks a Content-Type against the allowed MIME types,
+// which may use wildcards like "text/*". Unknown types are allowed.
+func (c *Crawler) allowedContentType(contentType string) bool {
+	if len(c.Settings.AllowedContentTypes) == 0 || contentType == "" {
+		return true
+	}
+	mediatype := mediaType(contentType)
+	for _, allowed := range c.Settings.AllowedContentTypes {
+		allowed = strings.ToLower(allowed)
+		if allowed == mediatype {
+			return true
+		}
+		if strings.HasSuffix(allowed, "/*") && strings.HasPrefix(mediatype, strings.TrimSuffix(allowed, "*")) {
+			return true
+		}
+	}
+	return false
+}
+
+// hasExcludedExtension is true if the path of the link ends in an excluded extension
+func (c *Crawler) hasExcludedExtension(link string) bool {
+	if len(c.Settings.ExcludeExtensions) == 0 {
+		return false
+	}
+	u, err := url.Parse(link)
+	if err != nil {
+		return false
+	}
+	ext := strings.ToLower(strings.TrimPrefix(path.Ext(u.Path), "."))
+	if ext == "" {
+		return false
+	}
+	for _, excluded := range c.Settings.ExcludeExtensions {
+		if ext == strings.ToLower(strings.TrimPrefix(excluded, ".")) {
+			return true
+		}
+	}
+	return false
+}
+
+// headContentType asks for the Content-Type of a URL without downloading it,
+// it returns "" if the server will not say
+func (c *Crawler) headContentType(link string) string {
+	req, err := http.NewRequest("HEAD", link, nil)
+	if err != nil {
+		return ""
+	}
+	c.setHeaders(req)
+	req, _ = c.withProxy(req)
+	resp, err := c.client.Do(req)
+	if err != nil {
+		log.Debugf("could not HEAD %s: %s", link, err.Error())
+		return ""
+	}
+	resp.Body.Close()
+	if resp.StatusCode != 200 {
+		return ""
+	}
+	return resp.Header.Get("Content-Type")
+}
+
+// peekContentType returns the Content-Type of a response, sniffing the start
+// of the body if there is no header. The body is left intact.
+func peekContentType(resp *http.Response) string {
+	if contentType := resp.Header.Get("Content-Type"); contentType != "" {
+		return contentType
+	}
+	start := make([]byte, 512)
+	n, _ := io.ReadFull(resp.Body, start)
+	start = start[:n]
+	resp.Body = struct {
+		io.Reader
+		io.Closer
+	}{io.MultiReader(bytes.NewReader(start), resp.Body), resp.Body}
+	if n == 0 {
+		return ""
+	}
+	// only part of a compressed body can be decoded, which is enough
+	decoded, _, _ := decodeBody(start, resp.Header.Get("Content-Encoding"), 0)
+	if len(decoded) == 0 {
+		return ""
+	}
+	return http.DetectContentType(decoded)
+}
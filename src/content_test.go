@@ -0,0 +1,82 @@
+package crawdad
+
+import (
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestContentFilters(t *testing.T) {
+	c, err := New()
+	assert.Nil(t, err)
+	assert.True(t, c.allowedContentType("application/pdf"))
+	c.Settings.AllowedContentTypes = []string{"text/*", "application/xhtml+xml"}
+	assert.True(t, c.allowedContentType("text/html; charset=utf-8"))
+	assert.True(t, c.allowedContentType("Application/XHTML+XML"))
+	assert.True(t, c.allowedContentType(""))
+	assert.False(t, c.allowedContentType("application/pdf"))
+
+	assert.False(t, c.hasExcludedExtension("http://a.com/b.pdf"))
+	c.Settings.ExcludeExtensions = []string{"pdf", ".ZIP"}
+	assert.True(t, c.hasExcludedExtension("http://a.com/b.PDF?x=1"))
+	assert.True(t, c.hasExcludedExtension("http://a.com/files/b.zip"))
+	assert.False(t, c.hasExcludedExtension("http://a.com/pdf"))
+}
+
+func TestPeekContentType(t *testing.T) {
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header()["Content-Type"] = nil
+		w.Write([]byte("%PDF-1.4 lots of bytes"))
+	}))
+	defer ts.Close()
+	resp, err := http.Get(ts.URL)
+	assert.Nil(t, err)
+	assert.Equal(t, "application/pdf", peekContentType(resp))
+	body, _ := ioutil.ReadAll(resp.Body)
+	assert.Equal(t, "%PDF-1.4 lots of bytes", string(body))
+}
+
+func TestSkipContent(t *testing.T) {
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/":
+			fmt.Fprint(w, `<a href="/file.zip">zip</a><a href="/video">video</a>`)
+		case "/video":
+			w.Header().Set("Content-Type", "video/mp4")
+			w.Write(make([]byte, 1000))
+		}
+	}))
+	defer ts.Close()
+
+	crawl, err := New()
+	assert.Nil(t, err)
+	crawl.RedisURL = "localhost"
+	crawl.RedisPort = "6377"
+	err = crawl.Init(Settings{
+		BaseURL:             ts.URL,
+		AllowedContentTypes: []string{"text/html"},
+		ExcludeExtensions:   []string{"zip"},
+	})
+	assert.Nil(t, err)
+	assert.Nil(t, crawl.Flush())
+
+	urls, _, err := crawl.scrapeLinks(ts.URL + "/")
+	assert.Nil(t, err)
+	for _, u := range urls {
+		_, err = crawl.addLinkToDo(u, false)
+		assert.Nil(t, err)
+	}
+	_, err = crawl.skipped.Get(ts.URL + "/file.zip").Result()
+	assert.Nil(t, err)
+
+	_, _, err = crawl.scrapeLinks(ts.URL + "/video")
+	assert.Equal(t, errMoved, err)
+	val, err := crawl.skipped.Get(ts.URL + "/video").Result()
+	assert.Nil(t, err)
+	assert.Contains(t, val, "video/mp4")
+	assert.Nil(t, crawl.Flush())
+}
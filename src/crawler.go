@@ -1,44 +1,64 @@
 package crawdad
 
 import (
-	"bufio"
-	"bytes"
 	"encoding/json"
 	"fmt"
-	"io/ioutil"
+	"io"
 	"math"
 	"net/http"
-	"net/url"
 	"strconv"
 	"strings"
 	"sync"
+	"sync/atomic"
 	"time"
 
 	humanize "github.com/dustin/go-humanize"
 	"github.com/go-redis/redis"
 	"github.com/goware/urlx"
 	"github.com/pkg/errors"
-	"github.com/schollz/collectlinks"
 	log "github.com/schollz/logger"
-	"github.com/schollz/pluck/pluck"
 	"github.com/schollz/progressbar/v2"
-	"golang.org/x/net/proxy"
 )
 
 func SetLogLevel(s string) {
 	log.SetLevel(s)
 }
 
+// SetLogOutput sends the logs to w instead of stdout
+func SetLogOutput(w io.Writer) {
+	log.SetOutput(w)
+}
+
 // Settings is the configuration across all instances
 type Settings struct {
 	BaseURL              string
 	PluckConfig          string
+	Content              ContentConfig
+	Fields               []Field
+	StructuredData       bool
 	KeywordsToExclude    []string
 	KeywordsToInclude    []string
 	AllowQueryParameters bool
 	AllowHashParameters  bool
 	DontFollowLinks      bool
 	RequirePluck         bool
+	Headers              map[string]string
+	HeaderProfiles       map[string]map[string]string
+	HeaderProfile        string
+	UserAgents           []string
+	Auth                 AuthConfig
+	AllowedContentTypes  []string
+	ContentTypeCheck     string
+	ExcludeExtensions    []string
+	LinkSources          []string
+	RespectNofollow      bool
+	IgnoreMetaRobots     bool
+	// CheckLinks also checks that links to other sites work, without
+	// downloading or following them
+	CheckLinks bool
+	// Sitemaps adds the URLs of the sitemaps in robots.txt as seeds when
+	// crawling starts
+	Sitemaps bool
 }
 
 // Crawler is the crawler instance
@@ -53,10 +73,35 @@ type Crawler struct {
 	Debug                    bool
 	Info                     bool
 	UseProxy                 bool
+	Proxies                  []string `json:"-"` // may contain credentials
+	ProxyRotation            string
+	TorControlAddress        string
+	TorControlPassword       string `json:"-"`
+	TorCookieFile            string
+	TorRenewEvery            int
 	UserAgent                string
 	Cookie                   string
+	HeaderProfile            string
 	EraseDB                  bool
 	MaxQueueSize             int
+	ConnectTimeout           time.Duration
+	TLSHandshakeTimeout      time.Duration
+	ResponseHeaderTimeout    time.Duration
+	Timeout                  time.Duration
+	MaxRedirects             int
+	OffsiteRedirects         string
+	CABundle                 string
+	Insecure                 bool
+	MaxBodySize              int64
+	TruncateLargeBodies      bool
+	Extractors               []Extractor `json:"-"` // run after the ones in the settings
+	ArchiveDir               string
+	WARCDir                  string
+	WARCMaxSize              int64
+	MetricsAddress           string
+	APIAddress               string
+	Project                  string // labels the metrics, the host of the base URL if empty
+	Node                     string // labels the metrics, the hostname if empty
 
 	// Public  options
 	Settings Settings
@@ -65,16 +110,35 @@ type Crawler struct {
 	programTime        time.Time
 	numberOfURLSParsed int
 	numTrash           int64
+	numSkipped         int64
 	numDone            int64
 	numToDo            int64
 	numDoing           int64
 	isRunning          bool
 	errors             int64
+	bytesTransferred   int64
+	bytesDecoded       int64
+	numWorkers         int64
 	client             *http.Client
+	jar                *sharedJar
+	loginState         loginState
+	proxies            *proxyPool
+	tor                *torController
+	numTorRequests     int64
+	numUserAgent       int64
 	todo               *redis.Client
 	doing              *redis.Client
+	shared             *redis.Client
 	done               *redis.Client
 	trash              *redis.Client
+	skipped            *redis.Client
+	extractorList      []Extractor
+	warc               *warcWriter
+	metrics            *metrics
+	apiServer          *http.Server
+	samples            sampleRing
+	stopWorker         chan struct{}
+	keywords           sync.RWMutex // guards the keywords of the Settings
 	wg                 sync.WaitGroup
 	queue              *syncmap
 	workersWorking     bool
@@ -98,6 +162,13 @@ func New() (*Crawler, error) {
 	c.MaximumNumberOfErrors = 20
 	c.errors = 0
 	c.MaxQueueSize = 500
+	c.ConnectTimeout = 10 * time.Second
+	c.TLSHandshakeTimeout = 10 * time.Second
+	c.ResponseHeaderTimeout = 10 * time.Second
+	c.Timeout = 10 * time.Second
+	c.MaxRedirects = 10
+	c.OffsiteRedirects = "follow"
+	c.WARCMaxSize = 1000000000
 	c.queue = new(syncmap)
 	c.queue.Lock()
 	c.queue.Data = make(map[string]struct{})
@@ -105,65 +176,49 @@ func New() (*Crawler, error) {
 	return c, err
 }
 
-// Init initializes the connection pool and the Redis client
-func (c *Crawler) Init(config ...Settings) (err error) {
-	// connect to Redis for the settings
-	remoteSettings := redis.NewClient(&redis.Options{
+// Connect connects to Redis and loads the settings, which is all that is
+// needed to work with the lists of a crawl without crawling. Settings that
+// are given are saved for every crawdad first.
+func (c *Crawler) Connect(config ...Settings) (err error) {
+	// connect to Redis for the settings and anything else shared
+	c.shared = redis.NewClient(&redis.Options{
 		Addr:     c.RedisURL + ":" + c.RedisPort,
 		Password: "",
 		DB:       4,
 	})
-	_, err = remoteSettings.Ping().Result()
+	_, err = c.shared.Ping().Result()
 	if err != nil {
 		return errors.New(fmt.Sprintf("Redis not available at %s:%s, did you run it? The easiest way is\n\n\tdocker run -d -v `pwd`:/data -p 6379:6379 redis\n\n", c.RedisURL, c.RedisPort))
 	}
 	if len(config) > 0 {
 		// save the supplied configuration to Redis
 		bSettings, err := json.Marshal(config[0])
-		_, err = remoteSettings.Set("settings", string(bSettings), 0).Result()
+		_, err = c.shared.Set("settings", string(bSettings), 0).Result()
 		if err != nil {
 			return err
 		}
-		log.Infof("saved settings: %v", config[0])
+		log.Infof("saved settings: %v", config[0].redacted())
 	}
 	// load the configuration from Redis
 	var val string
-	val, err = remoteSettings.Get("settings").Result()
+	val, err = c.shared.Get("settings").Result()
 	if err != nil {
 		return errors.New(fmt.Sprintf("You need to set the base settings. Use\n\n\tcrawdad -s %s -p %s -set -url http://www.URL.com\n\n", c.RedisURL, c.RedisPort))
 	}
 	err = json.Unmarshal([]byte(val), &c.Settings)
-	log.Infof("loaded settings: %v", c.Settings)
-
-	// Generate the connection pool
-	var tr *http.Transport
-	if c.UseProxy {
-		tbProxyURL, err := url.Parse("socks5://127.0.0.1:9050")
-		if err != nil {
-			log.Errorf("Failed to parse proxy URL: %v\n", err)
-			return err
-		}
-		tbDialer, err := proxy.FromURL(tbProxyURL, proxy.Direct)
-		if err != nil {
-			log.Errorf("Failed to obtain proxy dialer: %v\n", err)
-			return err
-		}
-		tr = &http.Transport{
-			MaxIdleConns:       c.MaxNumberConnections,
-			IdleConnTimeout:    15 * time.Second,
-			DisableCompression: true,
-			Dial:               tbDialer.Dial,
-		}
-	} else {
-		tr = &http.Transport{
-			MaxIdleConns:       c.MaxNumberConnections,
-			IdleConnTimeout:    15 * time.Second,
-			DisableCompression: true,
-		}
+	log.Infof("loaded settings: %v", c.Settings.redacted())
+	err = c.checkHeaderProfile()
+	if err != nil {
+		return err
 	}
-	c.client = &http.Client{
-		Transport: tr,
-		Timeout:   time.Duration(10 * time.Second),
+	switch c.Settings.ContentTypeCheck {
+	case "", "peek", "head":
+	default:
+		return errors.New("unknown content type check '" + c.Settings.ContentTypeCheck + "', use 'peek' or 'head'")
+	}
+	c.extractorList, err = c.extractors()
+	if err != nil {
+		return err
 	}
 
 	// Setup Redis client
@@ -195,6 +250,71 @@ func (c *Crawler) Init(config ...Settings) (err error) {
 		ReadTimeout: 30 * time.Second,
 		MaxRetries:  10,
 	})
+	c.skipped = redis.NewClient(&redis.Options{
+		Addr:        c.RedisURL + ":" + c.RedisPort,
+		Password:    "", // no password set
+		DB:          5,
+		ReadTimeout: 30 * time.Second,
+		MaxRetries:  10,
+	})
+	return
+}
+
+// Init connects to Redis, sets up everything needed to crawl and adds the
+// base URL to the todo list
+func (c *Crawler) Init(config ...Settings) (err error) {
+	err = c.Connect(config...)
+	if err != nil {
+		return
+	}
+	if c.WARCDir != "" {
+		c.warc, err = newWARCWriter(c.WARCDir, "crawdad", c.WARCMaxSize)
+		if err != nil {
+			return err
+		}
+	}
+
+	// Setup the proxies, using Tor if no others are given
+	proxies := c.Proxies
+	if len(proxies) == 0 && (c.UseProxy || c.TorControlAddress != "") {
+		proxies = []string{torProxyURL}
+	}
+	if len(proxies) > 0 {
+		c.proxies, err = newProxyPool(proxies, c.ProxyRotation)
+		if err != nil {
+			return err
+		}
+		log.Infof("using %d proxies with %s rotation", len(c.proxies.proxies), c.proxies.rotation)
+	}
+
+	// Generate the connection pool
+	c.client, err = c.newClient()
+	if err != nil {
+		return err
+	}
+	err = c.initAuth()
+	if err != nil {
+		return err
+	}
+
+	if c.TorControlAddress != "" {
+		c.tor = &torController{
+			address:    c.TorControlAddress,
+			password:   c.TorControlPassword,
+			cookieFile: c.TorCookieFile,
+		}
+		ip, errIP := c.getIP()
+		if errIP != nil {
+			log.Warn(errors.Wrap(errIP, "could not get Tor exit IP"))
+		} else {
+			c.tor.exitIP = ip
+			log.Infof("Tor exit IP is %s", ip)
+		}
+	}
+
+	if c.MetricsAddress != "" {
+		c.metrics = c.newMetrics()
+	}
 
 	if c.EraseDB {
 		log.Info("Flushed database")
@@ -205,7 +325,7 @@ func (c *Crawler) Init(config ...Settings) (err error) {
 	}
 	if len(c.Settings.BaseURL) > 0 {
 		log.Infof("Adding %s to URLs", c.Settings.BaseURL)
-		err = c.addLinkToDo(c.Settings.BaseURL, true)
+		_, err = c.addLinkToDo(c.Settings.BaseURL, true)
 		if err != nil {
 			return err
 		}
@@ -306,6 +426,8 @@ func (c *Crawler) Dump() (allKeys []string, err error) {
 	totalSize += tempSize
 	tempSize, _ = c.trash.DbSize().Result()
 	totalSize += tempSize
+	tempSize, _ = c.skipped.DbSize().Result()
+	totalSize += tempSize
 	bar := progressbar.NewOptions64(totalSize,
 		progressbar.OptionShowIts(),
 		progressbar.OptionShowCount(),
@@ -370,6 +492,21 @@ func (c *Crawler) Dump() (allKeys []string, err error) {
 		return nil, err
 	}
 	allKeys = append(allKeys, keys[:i]...)
+
+	keySize, _ = c.skipped.DbSize().Result()
+	keys = make([]string, keySize*2)
+	i = 0
+	iter = c.skipped.Scan(0, "", 0).Iterator()
+	for iter.Next() {
+		bar.Add(1)
+		keys[i] = iter.Val()
+		i++
+	}
+	if err := iter.Err(); err != nil {
+		log.Error("Problem getting skipped")
+		return nil, err
+	}
+	allKeys = append(allKeys, keys[:i]...)
 	return
 }
 
@@ -379,25 +516,28 @@ func (c *Crawler) getIP() (ip string, err error) {
 		log.Error("Problem making request")
 		return
 	}
-	if c.UserAgent != "" {
-		log.Debugf("Setting useragent string to '%s'", c.UserAgent)
-		req.Header.Set("User-Agent", c.UserAgent)
-	}
+	c.setHeaders(req)
 	resp, err := c.client.Do(req)
 	if err != nil {
 		return
 	}
 	defer resp.Body.Close()
 
-	ipB, err := ioutil.ReadAll(resp.Body)
+	ipB, _, err := readBody(resp.Body, c.MaxBodySize)
+	if err != nil {
+		return "", err
+	}
+	ipB, _, err = decodeBody(ipB, resp.Header.Get("Content-Encoding"), c.MaxBodySize)
 	if err != nil {
 		return "", err
 	}
-	ip = string(ipB)
+	ip = strings.TrimSpace(string(ipB))
 	return
 }
 
-func (c *Crawler) addLinkToDo(link string, force bool) (err error) {
+// addLinkToDo puts a link in todo, unless it is already known, returning
+// whether it was added
+func (c *Crawler) addLinkToDo(link string, force bool) (added bool, err error) {
 	if !force {
 		// add only if it isn't already in one of the databases
 		_, err = c.todo.Get(link).Result()
@@ -416,10 +556,21 @@ func (c *Crawler) addLinkToDo(link string, force bool) (err error) {
 		if err != redis.Nil {
 			return
 		}
+		_, err = c.skipped.Get(link).Result()
+		if err != redis.Nil {
+			return
+		}
+	}
+
+	// assets with excluded extensions are never downloaded
+	if c.hasExcludedExtension(link) {
+		err = c.moveToSkipped(Record{URL: link, Reason: "extension"})
+		return
 	}
 
 	// add it to the todo list
 	err = c.todo.Set(link, "", 0).Err()
+	added = err == nil
 	return
 }
 
@@ -441,86 +592,239 @@ func (c *Crawler) Flush() (err error) {
 	if err != nil {
 		return
 	}
+	_, err = c.skipped.FlushAll().Result()
+	if err != nil {
+		return
+	}
 	return
 }
 
-func (c *Crawler) scrapeLinks(url string) (linkCandidates []string, pluckedData string, err error) {
+func (c *Crawler) scrapeLinks(url string) (linkCandidates []string, record Record, err error) {
 	log.Debugf("Scraping %s", url)
 	if len(url) == 0 {
 		return
 	}
+	if c.Settings.CheckLinks && !c.inScope(url) {
+		record, err = c.checkLink(url)
+		return
+	}
 	req, err := http.NewRequest("GET", url, nil)
 	if err != nil {
-		err = errors.Wrap(err, "could not make New Request for '"+url+"'")
+		err = retryError{"error", errors.Wrap(err, "could not make New Request for '"+url+"'")}
 		return
 	}
-	if c.UserAgent != "" {
-		log.Debugf("Setting useragent string to '%s'", c.UserAgent)
-		req.Header.Set("User-Agent", c.UserAgent)
+	requestHeaders := c.setHeaders(req)
+	req, proxy := c.withProxy(req)
+
+	if c.Settings.ContentTypeCheck == "head" && len(c.Settings.AllowedContentTypes) > 0 {
+		contentType := c.headContentType(url)
+		if !c.allowedContentType(contentType) {
+			err = c.moveToSkipped(Record{URL: url, ContentType: mediaType(contentType), Reason: "content type"})
+			if err == nil {
+				err = errMoved
+			}
+			return
+		}
 	}
-	if c.Cookie != "" {
-		log.Debugf("Setting cookie")
-		req.Header.Set("Cookie", c.Cookie)
+
+	if c.tor != nil && c.TorRenewEvery > 0 && atomic.AddInt64(&c.numTorRequests, 1)%int64(c.TorRenewEvery) == 0 {
+		c.renewTorCircuit()
 	}
 
+	start := time.Now()
 	resp, err := c.client.Do(req)
+	c.metrics.observeResponse(url, resp, time.Since(start))
 	if err != nil {
-		err = errors.Wrap(err, "could not make do request for "+url)
+		if proxy != nil {
+			c.proxies.markFailed(proxy, err)
+		}
+		err = retryError{"error", errors.Wrap(err, "could not make do request for "+url)}
 		return
 	}
 	defer resp.Body.Close()
+	if proxy != nil {
+		c.proxies.markHealthy(proxy)
+		record.Proxy = proxy.name
+	}
+
+	if resp.StatusCode == 401 && c.sessionExpired(url, resp, nil) {
+		err = c.relogin(url)
+		return
+	}
 
 	if resp.StatusCode != 200 {
-		c.doing.Del(url).Result()
-		c.todo.Del(url).Result()
-		c.trash.Set(url, "", 0).Result()
 		if resp.StatusCode == 403 {
 			c.errors++
-			if c.errors > int64(c.MaximumNumberOfErrors) {
-				err = errors.New(fmt.Sprintf("Got code %d for %s", resp.StatusCode, url))
+			if c.tor != nil && c.errors <= int64(c.MaximumNumberOfErrors) {
+				// probably the exit is blocked, so retry on a new circuit
+				c.renewTorCircuit()
+				err = errors.New(fmt.Sprintf("Got code %d for %s, renewed Tor circuit", resp.StatusCode, url))
 				return
 			}
 		}
+		c.moveToTrash(Record{
+			URL:            url,
+			StatusCode:     resp.StatusCode,
+			FetchedAt:      time.Now().UTC(),
+			FetchMillis:    int64(time.Since(start) / time.Millisecond),
+			Proxy:          record.Proxy,
+			RequestHeaders: requestHeaders,
+			Redirects:      redirectChain(resp),
+			ContentType:    mediaType(resp.Header.Get("Content-Type")),
+			Reason:         "status",
+		})
+		if resp.StatusCode == 403 && c.errors > int64(c.MaximumNumberOfErrors) {
+			err = errors.New(fmt.Sprintf("Got code %d for %s", resp.StatusCode, url))
+			return
+		}
+		err = errMoved
 		return
 	}
 
 	// reset errors as long as the code is good
 	c.errors = 0
 
-	// copy resp.Body
-	var bodyBytes []byte
-	bodyBytes, _ = ioutil.ReadAll(resp.Body)
-	resp.Body = ioutil.NopCloser(bytes.NewBuffer(bodyBytes))
+	record.URL = url
+	record.StatusCode = resp.StatusCode
+	record.RequestHeaders = requestHeaders
+	record.Redirects = redirectChain(resp)
+	record.FetchedAt = time.Now().UTC()
+	record.ContentEncoding = resp.Header.Get("Content-Encoding")
+	record.LastModified = resp.Header.Get("Last-Modified")
+
+	// skip content that is not allowed before downloading it
+	contentType := peekContentType(resp)
+	record.ContentType = mediaType(contentType)
+	if !c.allowedContentType(contentType) {
+		record.Reason = "content type"
+		err = c.moveToSkipped(record)
+		if err == nil {
+			err = errMoved
+		}
+		return
+	}
+
+	// skip bodies that are too big, unless they can be truncated
+	if c.MaxBodySize > 0 && resp.ContentLength > c.MaxBodySize && !c.TruncateLargeBodies {
+		log.Debugf("skipping %s, it has %d bytes", url, resp.ContentLength)
+		record.Reason = "too large"
+		err = c.moveToSkipped(record)
+		if err == nil {
+			err = errMoved
+		}
+		return
+	}
 
-	// do plucking
-	if c.Settings.PluckConfig != "" {
-		plucker, _ := pluck.New()
-		err = plucker.LoadFromString(c.Settings.PluckConfig)
+	// read the body as it came over the wire and then decompress it
+	rawBytes, rawTruncated, err := readBody(resp.Body, c.MaxBodySize)
+	if err != nil {
+		err = retryError{"error", errors.Wrap(err, "could not read body of "+url)}
+		return
+	}
+	record.FetchMillis = int64(time.Since(start) / time.Millisecond)
+	bodyBytes, decodedTruncated, err := decodeBody(rawBytes, record.ContentEncoding, c.MaxBodySize)
+	if err != nil {
+		if !rawTruncated {
+			err = errors.Wrap(err, "could not decode body of "+url)
+			return
+		}
+		// a truncated compressed body can not be decoded all the way
+		err = nil
+	}
+	record.Truncated = rawTruncated || decodedTruncated
+	if record.Truncated && !c.TruncateLargeBodies {
+		log.Debugf("skipping %s, it is bigger than %d bytes", url, c.MaxBodySize)
+		record.Reason = "too large"
+		err = c.moveToSkipped(record)
+		if err == nil {
+			err = errMoved
+		}
+		return
+	}
+	if c.sessionExpired(url, resp, bodyBytes) {
+		err = c.relogin(url)
+		return
+	}
+	record.TransferSize = int64(len(rawBytes))
+	record.DecodedSize = int64(len(bodyBytes))
+	atomic.AddInt64(&c.bytesTransferred, record.TransferSize)
+	atomic.AddInt64(&c.bytesDecoded, record.DecodedSize)
+	c.metrics.observeBody(record.TransferSize, record.DecodedSize)
+
+	if c.ArchiveDir != "" {
+		record.ArchivePath, err = c.archive(rawBytes)
 		if err != nil {
 			return
 		}
-		err = plucker.Pluck(bufio.NewReader(bytes.NewReader(bodyBytes)))
+		record.ArchiveHead = responseHead(resp)
+	}
+	if c.warc != nil {
+		target := resp.Request.URL.String()
+		err = c.warc.writeExchange(target, record.FetchedAt, rawRequest("GET", target, requestHeaders), rawResponse(resp, rawBytes), rawBytes, record.Truncated)
 		if err != nil {
 			return
 		}
-		pluckedData = plucker.ResultJSON()
-		if c.Settings.RequirePluck && len(pluckedData) == 0 {
-			err = errors.New("no data plucked from " + url)
+	}
+
+	linkCandidates, err = c.processBody(&record, resp.Request.URL.String(), resp.Header, contentType, bodyBytes)
+	c.metrics.observeRobots(record)
+	return
+}
+
+// processBody gets the data and links out of a downloaded body, for a page
+// that ended up at pageURL
+func (c *Crawler) processBody(record *Record, pageURL string, header http.Header, contentType string, bodyBytes []byte) (linkCandidates []string, err error) {
+	url := record.URL
+	record.Links = nil
+	record.NoIndex, record.NoFollow = false, false
+	record.Canonical = ""
+
+	// everything is extracted from UTF-8
+	if isText(record.ContentType) {
+		bodyBytes, record.Charset, err = toUTF8(bodyBytes, contentType)
+		if err != nil {
+			err = errors.Wrap(err, "could not read "+url)
 			return
 		}
 	}
 
-	if c.Settings.DontFollowLinks {
+	// extract data, relative to where any redirects ended up
+	record.Extracted, err = c.extract(pageURL, header, bodyBytes)
+	if err != nil {
+		return
+	}
+	if c.Settings.RequirePluck && record.Extracted["pluck"] == nil {
+		err = errors.New("no data plucked from " + url)
 		return
 	}
 
-	// collect links
-	links := collectlinks.All(resp.Body)
+	// pages can ask not to be indexed or have their links followed
+	if !c.Settings.IgnoreMetaRobots {
+		robots := parseRobots(header, bodyBytes)
+		record.NoIndex = robots.noIndex
+		record.NoFollow = robots.noFollow
+	}
+
+	// pages can say which URL they should be known by, in the HTML or in a
+	// Link header
+	if canonical := extractLinks(bodyBytes, pageURL, []string{"canonical"}, false); len(canonical) > 0 {
+		record.Canonical = canonical[0].URL
+	} else {
+		record.Canonical = headerCanonical(header, pageURL)
+	}
+
+	// collect links, relative to where any redirects ended up
+	links := extractLinks(bodyBytes, pageURL, c.Settings.LinkSources, c.Settings.RespectNofollow)
+
+	// keep every link for the link graph, and follow the ones in scope
+	follow := !c.Settings.DontFollowLinks && !record.NoFollow
+	if !follow {
+		log.Debugf("not following links on %s", url)
+	}
+	seen := make(map[string]bool)
+	for _, found := range links {
+		link := found.URL
 
-	// find good links
-	linkCandidates = make([]string, len(links))
-	linkCandidatesI := 0
-	for _, link := range links {
 		// disallow query parameters, if not flagged
 		if strings.Contains(link, "?") && !c.Settings.AllowQueryParameters {
 			link = strings.Split(link, "?")[0]
@@ -531,72 +835,147 @@ func (c *Crawler) scrapeLinks(url string) (linkCandidates []string, pluckedData
 			link = strings.Split(link, "#")[0]
 		}
 
-		// add Base URL if it doesn't have
-		if !strings.Contains(link, "http") && len(link) > 2 {
-			if c.Settings.BaseURL[len(c.Settings.BaseURL)-1] != '/' && link[0] != '/' {
-				link = "/" + link
-			}
-			link = c.Settings.BaseURL + link
-		}
 		// log.Debugf("got '%s' from %s", link, url)
 
-		// skip links that have a different Base URL
-		if !strings.Contains(link, c.Settings.BaseURL) {
-			// log.Debugf("Skipping %s because it has a different base URL", link)
-			continue
-		}
-
 		// normalize the link
 		parsedLink, _ := urlx.Parse(link)
 		normalizedLink, _ := urlx.Normalize(parsedLink)
-		if len(normalizedLink) == 0 {
+		if len(normalizedLink) == 0 || seen[found.Source+" "+normalizedLink] {
 			continue
 		}
+		seen[found.Source+" "+normalizedLink] = true
+		found.URL = normalizedLink
+		found.InScope = c.inScope(link) && c.allowedKeywords(normalizedLink)
+		record.Links = append(record.Links, found)
 
-		// Exclude keywords, skip if any are found
-		foundExcludedKeyword := false
-		for _, keyword := range c.Settings.KeywordsToExclude {
-			if strings.Contains(normalizedLink, keyword) {
-				foundExcludedKeyword = true
-				// log.Debugf("Skipping %s because contains %s", link, keyword)
-				break
-			}
-		}
-		if foundExcludedKeyword {
-			continue
+		// If it passed all the tests, add to link candidates
+		if follow && (found.InScope || c.Settings.CheckLinks && c.isExternal(link)) {
+			linkCandidates = append(linkCandidates, normalizedLink)
 		}
+	}
+	return
+}
 
-		// Include keywords, skip if any are NOT found
-		foundIncludedKeyword := false
-		for _, keyword := range c.Settings.KeywordsToInclude {
-			if strings.Contains(normalizedLink, keyword) {
-				foundIncludedKeyword = true
-				break
-			}
+// allowedKeywords is true if a link has none of the excluded keywords and,
+// if there are any, one of the included keywords
+func (c *Crawler) allowedKeywords(link string) bool {
+	c.keywords.RLock()
+	defer c.keywords.RUnlock()
+
+	// Exclude keywords, skip if any are found
+	for _, keyword := range c.Settings.KeywordsToExclude {
+		if strings.Contains(link, keyword) {
+			return false
 		}
-		if !foundIncludedKeyword && len(c.Settings.KeywordsToInclude) > 0 {
-			continue
+	}
+
+	// Include keywords, skip if any are NOT found
+	if len(c.Settings.KeywordsToInclude) == 0 {
+		return true
+	}
+	for _, keyword := range c.Settings.KeywordsToInclude {
+		if strings.Contains(link, keyword) {
+			return true
 		}
+	}
+	return false
+}
 
-		// If it passed all the tests, add to link candidates
-		linkCandidates[linkCandidatesI] = normalizedLink
-		linkCandidatesI++
+// errMoved is returned by scrapeLinks when the URL was already moved out
+// of 'doing', so it should not be retried or put in 'done'
+var errMoved = errors.New("moved out of doing")
+
+// retriesKey counts the failed attempts at each URL in the shared database
+const retriesKey = "retries"
+
+// maxAttempts is how often a URL is tried before a retryError trashes it,
+// so pages that can not be reached or logged into end up with the broken links
+const maxAttempts = 3
+
+// retryError is a failed attempt at a URL that is retried, until it has
+// failed maxAttempts times and is trashed with the reason
+type retryError struct {
+	reason string
+	err    error
+}
+
+func (e retryError) Error() string {
+	return e.err.Error()
+}
+
+// retry counts a failed attempt at url, and trashes it once it has failed
+// too often. It is true if the URL should be tried again.
+func (c *Crawler) retry(url string, failure retryError) bool {
+	attempts, err := c.shared.HIncrBy(retriesKey, url, 1).Result()
+	if err == nil && attempts < maxAttempts {
+		return true
 	}
-	// trim candidate list
-	linkCandidates = linkCandidates[0:linkCandidatesI]
+	log.Warnf("giving up on %s after %d attempts: %s", url, attempts, failure.Error())
+	c.shared.HDel(retriesKey, url)
+	c.moveToTrash(Record{
+		URL:       url,
+		FetchedAt: time.Now().UTC(),
+		Reason:    failure.reason,
+		Error:     failure.Error(),
+	})
+	return false
+}
+
+// moveToTrash puts a URL that will not be retried in the trash, along with
+// the Reason it failed
+func (c *Crawler) moveToTrash(record Record) {
+	log.Debugf("trashing %s (%s)", record.URL, record.Reason)
+	bRecord, _ := json.Marshal(record)
+	c.doing.Del(record.URL).Result()
+	c.todo.Del(record.URL).Result()
+	c.trash.Set(record.URL, string(bRecord), 0).Result()
+	c.countFetch(record)
+	message := record.Error
+	if record.StatusCode != 0 {
+		message = strconv.Itoa(record.StatusCode) + " " + http.StatusText(record.StatusCode)
+	}
+	c.recordError(record.URL, message)
+}
 
+// moveToSkipped records a URL that was deliberately not downloaded
+func (c *Crawler) moveToSkipped(record Record) (err error) {
+	log.Debugf("skipping %s (%s)", record.URL, record.Reason)
+	bRecord, err := json.Marshal(record)
+	if err != nil {
+		return
+	}
+	c.doing.Del(record.URL).Result()
+	c.todo.Del(record.URL).Result()
+	err = c.skipped.Set(record.URL, string(bRecord), 0).Err()
 	return
 }
 
 func (c *Crawler) crawl(id int, jobs chan string) {
 	log.Debugf("initiated crawler %d", id)
 	for {
-		randomURL := <-jobs
+		var randomURL string
+		select {
+		case randomURL = <-jobs:
+		case <-c.stopWorker:
+			log.Debugf("stopped crawler %d", id)
+			return
+		}
 		log.Debugf("%d processing %s", id, randomURL)
 		// time the link getting process
-		urls, pluckedData, err := c.scrapeLinks(randomURL)
+		c.metrics.workerBusy(true)
+		urls, record, err := c.scrapeLinks(randomURL)
+		c.metrics.workerBusy(false)
+		if err == errMoved {
+			c.numberOfURLSParsed++
+			continue
+		}
+		if failure, ok := err.(retryError); ok && !c.retry(randomURL, failure) {
+			c.numberOfURLSParsed++
+			continue
+		}
 		if err != nil {
 			log.Warn(errors.Wrap(err, "worker #"+strconv.Itoa(id)+" failed scraping, will retry"))
+			c.recordError(randomURL, err.Error())
 			// move url to back to 'todo'
 			_, err2 := c.doing.Del(randomURL).Result()
 			if err2 != nil {
@@ -617,27 +996,35 @@ func (c *Crawler) crawl(id int, jobs chan string) {
 			log.Warn(errors.Wrap(err, "worker #"+strconv.Itoa(id)))
 			continue
 		}
-		_, err = c.done.Set(randomURL, pluckedData, 0).Result()
+		bRecord, err := json.Marshal(record)
 		if err != nil {
 			log.Warn(errors.Wrap(err, "worker #"+strconv.Itoa(id)))
 			continue
 		}
+		_, err = c.done.Set(randomURL, string(bRecord), 0).Result()
+		if err != nil {
+			log.Warn(errors.Wrap(err, "worker #"+strconv.Itoa(id)))
+			continue
+		}
+		c.metrics.pageFetched()
+		c.countFetch(record)
 
 		// add new urls to 'todo'
 		for _, url := range urls {
-			err = c.addLinkToDo(url, false)
+			_, err = c.addLinkToDo(url, false)
 			if err != nil {
-				log.Warn(errors.Wrap(err, "worker #"+string(id)))
+				log.Warn(errors.Wrap(err, "worker #"+strconv.Itoa(id)))
 				continue
 			}
 		}
-		log.Debugf("worker #%d: %d urls and %d bytes from %s [%s]", id, len(urls), len(pluckedData), randomURL, time.Since(t).String())
+		log.Debugf("worker #%d: %d urls and %d extracted sections from %s [%s]", id, len(urls), len(record.Extracted), randomURL, time.Since(t).String())
 		c.numberOfURLSParsed++
 	}
-	log.Infof("%d exiting", id)
 }
 
-func (c *Crawler) AddSeeds(seeds []string, force ...bool) (err error) {
+// AddSeeds adds URLs to todo, returning how many of them were not already
+// known
+func (c *Crawler) AddSeeds(seeds []string, force ...bool) (added int, err error) {
 	// add beginning link
 	var bar *progressbar.ProgressBar
 	if len(seeds) > 100 {
@@ -653,12 +1040,24 @@ func (c *Crawler) AddSeeds(seeds []string, force ...bool) (err error) {
 		if len(seeds) > 100 {
 			bar.Add(1)
 		}
-		err = c.addLinkToDo(seed, toForce)
+		if seed == "" {
+			continue
+		}
+		var isNew bool
+		isNew, err = c.addLinkToDo(seed, toForce)
+		if err != nil {
+			return
+		}
+		if isNew {
+			added++
+		}
+		// seeds are remembered to find orphan pages
+		err = c.shared.SAdd(seedsKey, seed).Err()
 		if err != nil {
 			return
 		}
 	}
-	log.Infof("Added %d seed links", len(seeds))
+	log.Infof("Added %d seed links", added)
 	return
 }
 
@@ -667,15 +1066,45 @@ func (c *Crawler) AddSeeds(seeds []string, force ...bool) (err error) {
 func (c *Crawler) Crawl() (err error) {
 	defer c.stopCrawling()
 	log.Infof("\nStarting crawl on %s\n\n", c.Settings.BaseURL)
-	b, _ := json.MarshalIndent(c, "", " ")
+	b, _ := json.MarshalIndent(c.Settings.redacted(), "", " ")
 	log.Infof("Settings:\n%s\n\n", b)
 	c.programTime = time.Now()
 	c.numberOfURLSParsed = 0
 	c.isRunning = true
+	err = c.login()
+	if err != nil {
+		return
+	}
+	if c.Settings.Sitemaps {
+		if errSitemaps := c.SeedSitemaps(); errSitemaps != nil {
+			log.Warn(errSitemaps)
+		}
+	}
+	if c.metrics != nil {
+		err = c.metrics.serve(c.MetricsAddress)
+		if err != nil {
+			return
+		}
+	}
+	if c.APIAddress != "" {
+		err = c.serveAPI()
+		if err != nil {
+			return
+		}
+	}
 	go c.contantlyPrintStats()
 
+	// workers set through the API in an earlier crawl would override
+	// --workers
+	err = c.shared.Del(workersKey).Err()
+	if err != nil {
+		return
+	}
 	var jobs chan string = make(chan string)
-	for w := 0; w < c.MaxNumberWorkers; w++ {
+	c.stopWorker = make(chan struct{})
+	workers := c.MaxNumberWorkers
+	atomic.StoreInt64(&c.numWorkers, int64(workers))
+	for w := 0; w < workers; w++ {
 		go c.crawl(w, jobs)
 	}
 
@@ -683,6 +1112,16 @@ func (c *Crawler) Crawl() (err error) {
 	for {
 		time.Sleep(1 * time.Second)
 
+		// the API can pause the crawl and change the workers and keywords of
+		// every node
+		if paused, _ := c.Paused(); paused {
+			continue
+		}
+		workers = c.workersFromAPI(workers, jobs)
+		if errReload := c.reloadKeywords(); errReload != nil {
+			log.Warn(errors.Wrap(errReload, "could not reload keywords"))
+		}
+
 		currentDoing, _ := c.doing.DbSize().Result()
 		if int(currentDoing) > c.MaxQueueSize {
 			time.Sleep(3 * time.Second)
@@ -710,7 +1149,7 @@ func (c *Crawler) Crawl() (err error) {
 		}
 
 		urlsToDoMap := make(map[string]struct{})
-		for i := 0; i < c.MaxNumberWorkers; i++ {
+		for i := 0; i < workers; i++ {
 			key, errRandom := c.todo.RandomKey().Result()
 			if errRandom != nil {
 				log.Warn(errRandom)
@@ -759,6 +1198,15 @@ func (c *Crawler) Crawl() (err error) {
 func (c *Crawler) stopCrawling() {
 	c.isRunning = false
 	c.printStats()
+	c.metrics.close()
+	if c.apiServer != nil {
+		c.apiServer.Close()
+	}
+	if c.warc != nil {
+		if err := c.warc.close(); err != nil {
+			log.Error(err)
+		}
+	}
 }
 
 func round(f float64) int {
@@ -786,6 +1234,10 @@ func (c *Crawler) updateListCounts() (err error) {
 	if err != nil {
 		return
 	}
+	c.numSkipped, err = c.skipped.DbSize().Result()
+	if err != nil {
+		return
+	}
 	return nil
 }
 
@@ -809,7 +1261,7 @@ func (c *Crawler) printStats() {
 	if len(printURL) > 17 {
 		printURL = printURL[:17]
 	}
-	log.Infof("[%s] parsed:%s, rate:%d, todo:%s, done:%s, doing:%s, trash:%s, errors:%s",
+	stats := fmt.Sprintf("[%s] parsed:%s, rate:%d, todo:%s, done:%s, doing:%s, trash:%s, skipped:%s, errors:%s, transferred:%s, decoded:%s",
 		printURL,
 		humanize.Comma(int64(c.numberOfURLSParsed)),
 		URLSPerSecond,
@@ -817,5 +1269,12 @@ func (c *Crawler) printStats() {
 		humanize.Comma(int64(c.numDone)),
 		humanize.Comma(int64(c.numDoing)),
 		humanize.Comma(int64(c.numTrash)),
-		humanize.Comma(int64(c.errors)))
+		humanize.Comma(int64(c.numSkipped)),
+		humanize.Comma(int64(c.errors)),
+		humanize.Bytes(uint64(atomic.LoadInt64(&c.bytesTransferred))),
+		humanize.Bytes(uint64(atomic.LoadInt64(&c.bytesDecoded))))
+	if exitIP := c.exitIP(); exitIP != "" {
+		stats += ", exit:" + exitIP
+	}
+	log.Info(stats)
 }
@@ -0,0 +1,496 @@
+package crawdad
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/url"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/go-redis/redis"
+	"github.com/pkg/errors"
+)
+
+// keys in the shared database with what the dashboard shows of every node
+const (
+	statusCodesKey  = "status_codes"
+	hostsKey        = "hosts"
+	recentErrorsKey = "recent_errors"
+	// numRecentErrors is how many errors are kept
+	numRecentErrors = 100
+	// maxHosts is how many hosts are counted, so that checking the links of
+	// a site doesn't count every host on the web. The rest are counted
+	// together under otherHosts.
+	maxHosts   = 1000
+	otherHosts = "(other hosts)"
+)
+
+// the dashboard charts the last numSamples of the stats, taken every
+// sampleInterval by the node that serves it
+const (
+	numSamples     = 300
+	sampleInterval = 2 * time.Second
+)
+
+// RecentError is a URL that failed
+type RecentError struct {
+	Time  time.Time `json:"time"`
+	URL   string    `json:"url"`
+	Error string    `json:"error"`
+}
+
+// Count is how often something was seen
+type Count struct {
+	Name  string `json:"name"`
+	Count int64  `json:"count"`
+}
+
+// Sample is the stats at a point in time
+type Sample struct {
+	Time time.Time `json:"time"`
+	Stats
+}
+
+// sampleRing keeps the latest samples
+type sampleRing struct {
+	sync.Mutex
+	samples []Sample
+	next    int
+}
+
+func (r *sampleRing) add(sample Sample) {
+	r.Lock()
+	defer r.Unlock()
+	if len(r.samples) < numSamples {
+		r.samples = append(r.samples, sample)
+		return
+	}
+	r.samples[r.next] = sample
+	r.next = (r.next + 1) % numSamples
+}
+
+// list is the samples, oldest first
+func (r *sampleRing) list() []Sample {
+	r.Lock()
+	defer r.Unlock()
+	samples := make([]Sample, 0, len(r.samples))
+	samples = append(samples, r.samples[r.next:]...)
+	return append(samples, r.samples[:r.next]...)
+}
+
+// sampleStats keeps the history of the stats while the API is served, so the
+// dashboard can chart it from when it is opened
+func (c *Crawler) sampleStats() {
+	for c.isRunning {
+		stats, err := c.Stats()
+		if err == nil {
+			c.samples.add(Sample{Time: time.Now().UTC(), Stats: stats})
+		}
+		time.Sleep(sampleInterval)
+	}
+}
+
+// countFetch counts the status code and host of a response, for the
+// dashboard
+func (c *Crawler) countFetch(record Record) {
+	if c.APIAddress == "" {
+		return
+	}
+	if record.StatusCode != 0 {
+		c.shared.HIncrBy(statusCodesKey, strconv.Itoa(record.StatusCode), 1)
+	}
+	u, err := url.Parse(record.URL)
+	if err != nil || u.Hostname() == "" {
+		return
+	}
+	host := u.Hostname()
+	if known, _ := c.shared.HExists(hostsKey, host).Result(); !known {
+		if n, _ := c.shared.HLen(hostsKey).Result(); n >= maxHosts {
+			host = otherHosts
+		}
+	}
+	c.shared.HIncrBy(hostsKey, host, 1)
+}
+
+// recordError keeps the latest errors, for the dashboard
+func (c *Crawler) recordError(url, message string) {
+	if c.APIAddress == "" {
+		return
+	}
+	b, _ := json.Marshal(RecentError{Time: time.Now().UTC(), URL: url, Error: message})
+	c.shared.LPush(recentErrorsKey, string(b))
+	c.shared.LTrim(recentErrorsKey, 0, numRecentErrors-1)
+}
+
+// RecentErrors are the latest errors of every node, newest first
+func (c *Crawler) RecentErrors() (recent []RecentError, err error) {
+	vals, err := c.shared.LRange(recentErrorsKey, 0, numRecentErrors-1).Result()
+	if err != nil {
+		return
+	}
+	recent = make([]RecentError, 0, len(vals))
+	for _, val := range vals {
+		var e RecentError
+		if json.Unmarshal([]byte(val), &e) == nil {
+			recent = append(recent, e)
+		}
+	}
+	return
+}
+
+// counts reads a hash of counts, most first
+func (c *Crawler) counts(key string, limit int) (counts []Count, err error) {
+	m, err := c.shared.HGetAll(key).Result()
+	if err != nil {
+		return
+	}
+	counts = make([]Count, 0, len(m))
+	for name, val := range m {
+		n, _ := strconv.ParseInt(val, 10, 64)
+		counts = append(counts, Count{Name: name, Count: n})
+	}
+	sort.Slice(counts, func(i, j int) bool {
+		if counts[i].Count != counts[j].Count {
+			return counts[i].Count > counts[j].Count
+		}
+		return counts[i].Name < counts[j].Name
+	})
+	if limit > 0 && len(counts) > limit {
+		counts = counts[:limit]
+	}
+	return
+}
+
+// SearchURLs finds URLs of a list that contain the query, a page at a time.
+// The cursor of the next page is 0 at the end.
+func (c *Crawler) SearchURLs(state, query string, cursor uint64) (urls []string, next uint64, err error) {
+	var db *redis.Client
+	switch state {
+	case "todo":
+		db = c.todo
+	case "doing":
+		db = c.doing
+	case "done":
+		db = c.done
+	case "trash":
+		db = c.trash
+	case "skipped":
+		db = c.skipped
+	default:
+		err = errors.New("unknown state '" + state + "', use " + strings.Join(ExportStates, ", "))
+		return
+	}
+	// the query is matched literally
+	pattern := "*" + globEscaper.Replace(query) + "*"
+	urls, next, err = db.Scan(cursor, pattern, 100).Result()
+	if urls == nil {
+		urls = []string{}
+	}
+	return
+}
+
+var globEscaper = strings.NewReplacer(`\`, `\\`, `*`, `\*`, `?`, `\?`, `[`, `\[`, `]`, `\]`)
+
+// dashboardRoutes adds the dashboard and the API it uses
+func (c *Crawler) dashboardRoutes(mux *http.ServeMux) {
+	mux.HandleFunc("/", func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/" {
+			http.NotFound(w, r)
+			return
+		}
+		w.Header().Set("Content-Type", "text/html; charset=utf-8")
+		w.Write([]byte(dashboardHTML))
+	})
+	limit := func(r *http.Request) int {
+		n, _ := strconv.Atoi(r.URL.Query().Get("limit"))
+		return n
+	}
+	mux.HandleFunc("/api/status-codes", c.apiMethods(map[string]apiFunc{
+		"GET": func(r *http.Request) (interface{}, error) {
+			return c.counts(statusCodesKey, limit(r))
+		},
+	}))
+	mux.HandleFunc("/api/hosts", c.apiMethods(map[string]apiFunc{
+		"GET": func(r *http.Request) (interface{}, error) {
+			return c.counts(hostsKey, limit(r))
+		},
+	}))
+	mux.HandleFunc("/api/samples", c.apiMethods(map[string]apiFunc{
+		"GET": func(r *http.Request) (interface{}, error) {
+			return c.samples.list(), nil
+		},
+	}))
+	mux.HandleFunc("/api/errors", c.apiMethods(map[string]apiFunc{
+		"GET": func(r *http.Request) (interface{}, error) {
+			return c.RecentErrors()
+		},
+	}))
+	mux.HandleFunc("/api/urls", c.apiMethods(map[string]apiFunc{
+		"GET": func(r *http.Request) (interface{}, error) {
+			cursor, _ := strconv.ParseUint(r.URL.Query().Get("cursor"), 10, 64)
+			urls, next, err := c.SearchURLs(r.URL.Query().Get("state"), r.URL.Query().Get("q"), cursor)
+			if err != nil {
+				return nil, apiError{http.StatusBadRequest, err}
+			}
+			return map[string]interface{}{"urls": urls, "cursor": next}, nil
+		},
+	}))
+}
+
+// dashboardHTML is the whole dashboard, so it needs nothing but the binary
+const dashboardHTML = `<!DOCTYPE html>
+<html lang="en">
+<head>
+<meta charset="utf-8">
+<meta name="viewport" content="width=device-width, initial-scale=1">
+<title>crawdad</title>
+<style>
+body { font-family: -apple-system, "Segoe UI", Helvetica, Arial, sans-serif; margin: 0; background: #f5f5f5; color: #222; }
+header { background: #b03a2e; color: #fff; padding: 0.6em 1em; display: flex; align-items: center; gap: 1em; }
+header h1 { font-size: 1.3em; margin: 0; flex: 1; }
+header button { font-size: 0.9em; }
+main { display: grid; grid-template-columns: repeat(auto-fit, minmax(420px, 1fr)); gap: 1em; padding: 1em; }
+section { background: #fff; border-radius: 4px; padding: 0.8em 1em; box-shadow: 0 1px 2px rgba(0,0,0,0.1); overflow: hidden; }
+section.wide { grid-column: 1 / -1; }
+h2 { font-size: 1em; margin: 0 0 0.6em; color: #555; }
+.numbers { display: flex; flex-wrap: wrap; gap: 1.2em; }
+.number b { display: block; font-size: 1.5em; }
+.number span { color: #777; font-size: 0.85em; }
+canvas { width: 100%; height: 200px; }
+table { width: 100%; border-collapse: collapse; font-size: 0.9em; }
+td, th { text-align: left; padding: 0.2em 0.4em; border-bottom: 1px solid #eee; }
+td.count { text-align: right; width: 5em; }
+td.bar div { background: #d98880; height: 0.8em; }
+.url { word-break: break-all; }
+.legend span { margin-right: 1em; font-size: 0.85em; }
+.legend i { display: inline-block; width: 0.8em; height: 0.8em; margin-right: 0.3em; }
+#browser form { display: flex; gap: 0.5em; margin-bottom: 0.6em; }
+#browser input { flex: 1; }
+#results a { color: #1f618d; cursor: pointer; }
+pre { background: #f8f8f8; padding: 0.6em; overflow: auto; max-height: 300px; font-size: 0.8em; }
+</style>
+</head>
+<body>
+<header>
+<h1>crawdad</h1>
+<span id="state"></span>
+<button id="pause">Pause</button>
+<button id="resume">Resume</button>
+</header>
+<main>
+<section class="wide">
+<h2>Progress</h2>
+<div class="numbers" id="numbers"></div>
+</section>
+<section>
+<h2>Queue sizes</h2>
+<canvas id="queues"></canvas>
+<div class="legend" id="queues-legend"></div>
+</section>
+<section>
+<h2>Throughput (URLs per minute)</h2>
+<canvas id="throughput"></canvas>
+</section>
+<section>
+<h2>Status codes</h2>
+<table id="status-codes"></table>
+</section>
+<section>
+<h2>Top hosts</h2>
+<table id="hosts"></table>
+</section>
+<section class="wide">
+<h2>Recent errors</h2>
+<table id="errors"></table>
+</section>
+<section class="wide" id="browser">
+<h2>URLs</h2>
+<form id="search">
+<select id="search-state">
+<option>todo</option><option>doing</option><option selected>done</option><option>trash</option><option>skipped</option>
+</select>
+<input id="search-query" placeholder="part of a URL">
+<button>Search</button>
+</form>
+<table id="results"></table>
+<button id="more" hidden>More</button>
+<pre id="record" hidden></pre>
+</section>
+</main>
+<script>
+"use strict";
+var colors = {todo: "#2e86c1", doing: "#f1c40f", done: "#28b463", trash: "#cb4335", skipped: "#909497"};
+var samples = [];
+var maxHistory = 300;
+
+function get(path) {
+  return fetch(path).then(function(resp) { return resp.json(); });
+}
+
+function post(path) {
+  return fetch(path, {method: "POST", headers: {"Content-Type": "application/json"}}).then(function(resp) { return resp.json(); });
+}
+
+function el(tag, text, className) {
+  var e = document.createElement(tag);
+  if (text !== undefined) { e.textContent = text; }
+  if (className) { e.className = className; }
+  return e;
+}
+
+function fill(id, rows) {
+  var table = document.getElementById(id);
+  table.innerHTML = "";
+  rows.forEach(function(row) { table.appendChild(row); });
+}
+
+function bytes(n) {
+  var units = ["B", "kB", "MB", "GB", "TB"];
+  var i = 0;
+  while (n >= 1000 && i < units.length - 1) { n /= 1000; i++; }
+  return n.toFixed(i ? 1 : 0) + " " + units[i];
+}
+
+function drawChart(id, series) {
+  var canvas = document.getElementById(id);
+  var width = canvas.clientWidth, height = canvas.clientHeight;
+  canvas.width = width * window.devicePixelRatio;
+  canvas.height = height * window.devicePixelRatio;
+  var ctx = canvas.getContext("2d");
+  ctx.scale(window.devicePixelRatio, window.devicePixelRatio);
+  ctx.clearRect(0, 0, width, height);
+  var max = 1;
+  series.forEach(function(s) { s.values.forEach(function(v) { max = Math.max(max, v); }); });
+  ctx.fillStyle = "#777";
+  ctx.font = "11px sans-serif";
+  ctx.fillText(max.toLocaleString(), 2, 10);
+  series.forEach(function(s) {
+    ctx.strokeStyle = s.color;
+    ctx.lineWidth = 2;
+    ctx.beginPath();
+    s.values.forEach(function(v, i) {
+      var x = width * i / Math.max(1, maxHistory - 1);
+      var y = height - 2 - (height - 14) * v / max;
+      if (i === 0) { ctx.moveTo(x, y); } else { ctx.lineTo(x, y); }
+    });
+    ctx.stroke();
+  });
+}
+
+function updateStats() {
+  return get("/api/stats").then(function(stats) {
+    document.getElementById("state").textContent = stats.paused ? "paused" : "running";
+    var numbers = [
+      ["todo", stats.todo], ["doing", stats.doing], ["done", stats.done], ["trash", stats.trash],
+      ["skipped", stats.skipped], ["workers", stats.workers], ["parsed here", stats.parsed],
+      ["URLs/min here", stats.urls_per_minute], ["transferred", bytes(stats.bytes_transferred)],
+      ["decoded", bytes(stats.bytes_decoded)]
+    ];
+    fill("numbers", numbers.map(function(n) {
+      var div = el("div", undefined, "number");
+      div.appendChild(el("b", n[1].toLocaleString()));
+      div.appendChild(el("span", n[0]));
+      return div;
+    }));
+    stats.time = Date.now();
+    samples.push(stats);
+    if (samples.length > maxHistory) { samples.shift(); }
+    drawCharts();
+  });
+}
+
+function drawCharts() {
+  drawChart("queues", ["todo", "doing", "done", "trash", "skipped"].map(function(state) {
+    return {color: colors[state], values: samples.map(function(h) { return h[state]; })};
+  }));
+  // throughput is of every node, from how fast URLs leave the queue
+  drawChart("throughput", [{color: "#b03a2e", values: samples.map(function(h, i) {
+    if (i === 0) { return 0; }
+    var prev = samples[i - 1];
+    var finished = (h.done + h.trash + h.skipped) - (prev.done + prev.trash + prev.skipped);
+    return Math.max(0, Math.round(60000 * finished / (h.time - prev.time)));
+  })}]);
+}
+
+function countRows(counts) {
+  var max = counts.length ? counts[0].count : 1;
+  return counts.map(function(c) {
+    var tr = el("tr");
+    tr.appendChild(el("td", c.name, "url"));
+    tr.appendChild(el("td", c.count.toLocaleString(), "count"));
+    var bar = el("td", undefined, "bar");
+    var div = el("div");
+    div.style.width = (100 * c.count / max) + "%";
+    bar.appendChild(div);
+    tr.appendChild(bar);
+    return tr;
+  });
+}
+
+function updateTables() {
+  get("/api/status-codes").then(function(counts) { fill("status-codes", countRows(counts)); });
+  get("/api/hosts?limit=10").then(function(counts) { fill("hosts", countRows(counts)); });
+  get("/api/errors").then(function(errors) {
+    fill("errors", errors.slice(0, 20).map(function(e) {
+      var tr = el("tr");
+      tr.appendChild(el("td", new Date(e.time).toLocaleTimeString()));
+      tr.appendChild(el("td", e.url, "url"));
+      tr.appendChild(el("td", e.error));
+      return tr;
+    }));
+  });
+}
+
+var search = {cursor: 0};
+
+function showRecord(url) {
+  get("/api/url?url=" + encodeURIComponent(url)).then(function(state) {
+    var pre = document.getElementById("record");
+    pre.hidden = false;
+    pre.textContent = JSON.stringify(state, null, 2);
+  });
+}
+
+function searchURLs(more) {
+  if (!more) {
+    search = {cursor: 0, state: document.getElementById("search-state").value, q: document.getElementById("search-query").value};
+    fill("results", []);
+  }
+  var path = "/api/urls?state=" + search.state + "&q=" + encodeURIComponent(search.q) + "&cursor=" + search.cursor;
+  get(path).then(function(page) {
+    var table = document.getElementById("results");
+    page.urls.forEach(function(url) {
+      var tr = el("tr"), td = el("td", undefined, "url"), a = el("a", url);
+      a.onclick = function() { showRecord(url); };
+      td.appendChild(a);
+      tr.appendChild(td);
+      table.appendChild(tr);
+    });
+    search.cursor = page.cursor;
+    document.getElementById("more").hidden = page.cursor === 0;
+  });
+}
+
+document.getElementById("search").onsubmit = function(e) { e.preventDefault(); searchURLs(false); };
+document.getElementById("more").onclick = function() { searchURLs(true); };
+document.getElementById("pause").onclick = function() { post("/api/pause").then(updateStats); };
+document.getElementById("resume").onclick = function() { post("/api/resume").then(updateStats); };
+document.getElementById("queues-legend").innerHTML = Object.keys(colors).map(function(state) {
+  return '<span><i style="background:' + colors[state] + '"></i>' + state + "</span>";
+}).join("");
+
+// the history from before the page was opened is kept by the server
+get("/api/samples").then(function(history) {
+  samples = history.map(function(h) { h.time = Date.parse(h.time); return h; });
+  updateStats();
+});
+updateTables();
+setInterval(updateStats, 2000);
+setInterval(updateTables, 5000);
+</script>
+</body>
+</html>
+`
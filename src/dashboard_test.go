@@ -0,0 +1,130 @@
+package crawdad
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestDashboard(t *testing.T) {
+	crawl, err := New()
+	assert.Nil(t, err)
+	crawl.RedisPort = "6377"
+	err = crawl.Init(Settings{BaseURL: "https://example.com"})
+	assert.Nil(t, err)
+	assert.Nil(t, crawl.Flush())
+	server := httptest.NewServer(crawl.apiHandler())
+	defer server.Close()
+
+	get := func(path string, v interface{}) int {
+		resp, err := http.Get(server.URL + path)
+		assert.Nil(t, err)
+		defer resp.Body.Close()
+		if v != nil {
+			assert.Nil(t, json.NewDecoder(resp.Body).Decode(v))
+		}
+		return resp.StatusCode
+	}
+
+	// the page has everything it needs
+	resp, err := http.Get(server.URL + "/")
+	assert.Nil(t, err)
+	b, _ := ioutil.ReadAll(resp.Body)
+	resp.Body.Close()
+	assert.Equal(t, "text/html; charset=utf-8", resp.Header.Get("Content-Type"))
+	assert.Contains(t, string(b), "<title>crawdad</title>")
+	assert.NotContains(t, string(b), " src=")
+	assert.NotContains(t, string(b), "<link")
+	assert.Equal(t, 404, get("/nothing", nil))
+
+	// nothing is counted for a crawl without the dashboard
+	crawl.countFetch(Record{URL: "https://example.com/", StatusCode: 200})
+	crawl.recordError("https://example.com/", "connection refused")
+	n, err := crawl.shared.Exists(statusCodesKey, hostsKey, recentErrorsKey).Result()
+	assert.Nil(t, err)
+	assert.Equal(t, int64(0), n)
+
+	crawl.APIAddress = ":0"
+	for _, r := range []Record{
+		{URL: "https://example.com/", StatusCode: 200},
+		{URL: "https://example.com/a", StatusCode: 200},
+		{URL: "https://cdn.example.com/a.css", StatusCode: 200},
+	} {
+		crawl.countFetch(r)
+		b, _ := json.Marshal(r)
+		assert.Nil(t, crawl.done.Set(r.URL, string(b), 0).Err())
+	}
+	crawl.moveToTrash(Record{URL: "https://example.com/gone", StatusCode: 404, Reason: "status"})
+	crawl.moveToTrash(Record{URL: "https://example.com/down", Reason: "error", Error: "connection refused"})
+
+	var counts []Count
+	assert.Equal(t, 200, get("/api/status-codes", &counts))
+	assert.Equal(t, []Count{{"200", 3}, {"404", 1}}, counts)
+	assert.Equal(t, 200, get("/api/hosts?limit=1", &counts))
+	assert.Equal(t, []Count{{"example.com", 4}}, counts)
+
+	var recent []RecentError
+	assert.Equal(t, 200, get("/api/errors", &recent))
+	assert.Equal(t, 2, len(recent))
+	assert.Equal(t, "https://example.com/down", recent[0].URL)
+	assert.Equal(t, "connection refused", recent[0].Error)
+	assert.Equal(t, "404 Not Found", recent[1].Error)
+
+	// the search is literal and goes through every page of a list
+	var found []string
+	var page struct {
+		URLs   []string `json:"urls"`
+		Cursor uint64   `json:"cursor"`
+	}
+	for cursor := "0"; ; {
+		assert.Equal(t, 200, get("/api/urls?state=done&q=/a&cursor="+cursor, &page))
+		found = append(found, page.URLs...)
+		if page.Cursor == 0 {
+			break
+		}
+		cursor = strconv.FormatUint(page.Cursor, 10)
+	}
+	assert.ElementsMatch(t, []string{"https://example.com/a", "https://cdn.example.com/a.css"}, found)
+	assert.Equal(t, 200, get("/api/urls?state=done&q=*", &page))
+	assert.Empty(t, page.URLs)
+	assert.Equal(t, 400, get("/api/urls?state=lost", nil))
+
+	// the hosts past the first ones are counted together
+	for i := 0; i < maxHosts; i++ {
+		crawl.countFetch(Record{URL: "https://" + strconv.Itoa(i) + ".example.org/"})
+	}
+	crawl.countFetch(Record{URL: "https://example.com/b"})
+	assert.Equal(t, 200, get("/api/hosts?limit=2", &counts))
+	assert.Equal(t, []Count{{"example.com", 5}, {otherHosts, 2}}, counts)
+	assert.Nil(t, crawl.Flush())
+}
+
+func TestSampleRing(t *testing.T) {
+	crawl, err := New()
+	assert.Nil(t, err)
+	assert.Empty(t, crawl.samples.list())
+	for i := 0; i < numSamples+2; i++ {
+		crawl.samples.add(Sample{Stats: Stats{Done: int64(i)}})
+	}
+	samples := crawl.samples.list()
+	assert.Equal(t, numSamples, len(samples))
+	assert.Equal(t, int64(2), samples[0].Done)
+	assert.Equal(t, int64(numSamples+1), samples[numSamples-1].Done)
+
+	// the dashboard gets them as they are
+	server := httptest.NewServer(crawl.apiHandler())
+	defer server.Close()
+	resp, err := http.Get(server.URL + "/api/samples")
+	assert.Nil(t, err)
+	defer resp.Body.Close()
+	var served []map[string]interface{}
+	assert.Nil(t, json.NewDecoder(resp.Body).Decode(&served))
+	assert.Equal(t, numSamples, len(served))
+	assert.Equal(t, float64(2), served[0]["done"])
+	assert.Contains(t, served[0], "time")
+}
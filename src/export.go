@@ -0,0 +1,321 @@
+package crawdad
+
+import (
+	"bufio"
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/url"
+	"strings"
+	"time"
+
+	"github.com/go-redis/redis"
+	"github.com/pkg/errors"
+	log "github.com/schollz/logger"
+	parquetsource "github.com/xitongsys/parquet-go-source/writer"
+	"github.com/xitongsys/parquet-go/parquet"
+	parquetwriter "github.com/xitongsys/parquet-go/writer"
+)
+
+// ExportStates are the lists that URLs can be exported from
+var ExportStates = []string{"done", "trash", "skipped", "todo", "doing"}
+
+// DefaultExportColumns are the columns of a CSV export if none are chosen
+var DefaultExportColumns = []string{"state", "url", "status_code", "fetched_at", "content_type", "transfer_size", "decoded_size"}
+
+// ExportFilter picks which URLs are exported
+type ExportFilter struct {
+	// States are the lists to export from, only "done" if empty
+	States []string
+	// Host only exports URLs on this host
+	Host string
+	// Since and Until only export URLs fetched in this time, if they are set
+	Since time.Time
+	Until time.Time
+}
+
+// ExportRecord is a record with the list it came from
+type ExportRecord struct {
+	State string `json:"state"`
+	Record
+}
+
+// recordWriter writes exported records one at a time
+type recordWriter interface {
+	write(r ExportRecord) error
+	close() error
+}
+
+// Export streams the records of the URLs that pass the filter to w, as
+// "jsonl", "csv" with the given columns, or "parquet"
+func (c *Crawler) Export(w io.Writer, format string, filter ExportFilter, columns []string) (err error) {
+	var rw recordWriter
+	switch format {
+	case "jsonl":
+		rw = newJSONLWriter(w)
+	case "csv":
+		rw, err = newCSVWriter(w, columns)
+	case "parquet":
+		rw, err = newParquetWriter(w)
+	default:
+		err = errors.New("unknown export format '" + format + "', use jsonl, csv or parquet")
+	}
+	if err != nil {
+		return
+	}
+	numExported := 0
+	err = c.exportRecords(filter, func(r ExportRecord) error {
+		numExported++
+		return rw.write(r)
+	})
+	if errClose := rw.close(); err == nil {
+		err = errClose
+	}
+	log.Infof("exported %d URLs", numExported)
+	return
+}
+
+// exportRecords calls fn for each record that passes the filter, reading the
+// lists a page of keys at a time
+func (c *Crawler) exportRecords(filter ExportFilter, fn func(ExportRecord) error) (err error) {
+	states := filter.States
+	if len(states) == 0 {
+		states = []string{"done"}
+	}
+	for _, state := range states {
+		var db *redis.Client
+		switch state {
+		case "done":
+			db = c.done
+		case "trash":
+			db = c.trash
+		case "skipped":
+			db = c.skipped
+		case "todo":
+			db = c.todo
+		case "doing":
+			db = c.doing
+		default:
+			return errors.New("unknown state '" + state + "', use " + strings.Join(ExportStates, ", "))
+		}
+		var cursor uint64
+		for {
+			var keys []string
+			keys, cursor, err = db.Scan(cursor, "*", 1000).Result()
+			if err != nil {
+				return
+			}
+			if len(keys) > 0 {
+				var vals []interface{}
+				vals, err = db.MGet(keys...).Result()
+				if err != nil {
+					return
+				}
+				for i, key := range keys {
+					r := ExportRecord{State: state}
+					if val, ok := vals[i].(string); ok && val != "" {
+						json.Unmarshal([]byte(val), &r.Record)
+					}
+					if r.URL == "" {
+						r.URL = key
+					}
+					if !filter.pass(r) {
+						continue
+					}
+					err = fn(r)
+					if err != nil {
+						return
+					}
+				}
+			}
+			if cursor == 0 {
+				break
+			}
+		}
+	}
+	return
+}
+
+func (filter ExportFilter) pass(r ExportRecord) bool {
+	if filter.Host != "" {
+		u, err := url.Parse(r.URL)
+		if err != nil || !strings.EqualFold(u.Hostname(), filter.Host) {
+			return false
+		}
+	}
+	if !filter.Since.IsZero() && r.FetchedAt.Before(filter.Since) {
+		return false
+	}
+	if !filter.Until.IsZero() && (r.FetchedAt.IsZero() || r.FetchedAt.After(filter.Until)) {
+		return false
+	}
+	return true
+}
+
+type jsonlWriter struct {
+	w   *bufio.Writer
+	enc *json.Encoder
+}
+
+func newJSONLWriter(w io.Writer) *jsonlWriter {
+	bw := bufio.NewWriter(w)
+	return &jsonlWriter{w: bw, enc: json.NewEncoder(bw)}
+}
+
+func (j *jsonlWriter) write(r ExportRecord) error {
+	return j.enc.Encode(r)
+}
+
+func (j *jsonlWriter) close() error {
+	return j.w.Flush()
+}
+
+type csvWriter struct {
+	w       *csv.Writer
+	columns []string
+}
+
+func newCSVWriter(w io.Writer, columns []string) (cw *csvWriter, err error) {
+	if len(columns) == 0 {
+		columns = DefaultExportColumns
+	}
+	cw = &csvWriter{w: csv.NewWriter(w), columns: columns}
+	err = cw.w.Write(columns)
+	return
+}
+
+// write takes each column from the JSON of the record. Columns can reach into
+// nested values with dots, like "extracted.pluck.title", and anything that is
+// not a string or number is written as JSON.
+func (cw *csvWriter) write(r ExportRecord) (err error) {
+	b, err := json.Marshal(r)
+	if err != nil {
+		return
+	}
+	var m map[string]interface{}
+	err = json.Unmarshal(b, &m)
+	if err != nil {
+		return
+	}
+	row := make([]string, len(cw.columns))
+	for i, column := range cw.columns {
+		var v interface{} = m
+		for _, key := range strings.Split(column, ".") {
+			nested, ok := v.(map[string]interface{})
+			if !ok {
+				v = nil
+				break
+			}
+			v = nested[key]
+		}
+		switch value := v.(type) {
+		case nil:
+		case string:
+			row[i] = value
+		case float64, bool:
+			row[i] = fmt.Sprint(value)
+		default:
+			b, _ := json.Marshal(value)
+			row[i] = string(b)
+		}
+	}
+	return cw.w.Write(row)
+}
+
+func (cw *csvWriter) close() error {
+	cw.w.Flush()
+	return cw.w.Error()
+}
+
+// parquetRecord is the Parquet schema of an exported record, with the nested
+// values as JSON
+type parquetRecord struct {
+	State           string `parquet:"name=state, type=UTF8, encoding=PLAIN_DICTIONARY"`
+	URL             string `parquet:"name=url, type=UTF8"`
+	StatusCode      int32  `parquet:"name=status_code, type=INT32"`
+	FetchedAt       int64  `parquet:"name=fetched_at, type=TIMESTAMP_MILLIS"`
+	FetchMillis     int64  `parquet:"name=fetch_ms, type=INT64"`
+	Proxy           string `parquet:"name=proxy, type=UTF8, encoding=PLAIN_DICTIONARY"`
+	ContentType     string `parquet:"name=content_type, type=UTF8, encoding=PLAIN_DICTIONARY"`
+	ContentEncoding string `parquet:"name=content_encoding, type=UTF8, encoding=PLAIN_DICTIONARY"`
+	Charset         string `parquet:"name=charset, type=UTF8, encoding=PLAIN_DICTIONARY"`
+	TransferSize    int64  `parquet:"name=transfer_size, type=INT64"`
+	DecodedSize     int64  `parquet:"name=decoded_size, type=INT64"`
+	Truncated       bool   `parquet:"name=truncated, type=BOOLEAN"`
+	NoIndex         bool   `parquet:"name=noindex, type=BOOLEAN"`
+	NoFollow        bool   `parquet:"name=nofollow, type=BOOLEAN"`
+	Reason          string `parquet:"name=reason, type=UTF8, encoding=PLAIN_DICTIONARY"`
+	Error           string `parquet:"name=error, type=UTF8"`
+	Checked         bool   `parquet:"name=checked, type=BOOLEAN"`
+	Canonical       string `parquet:"name=canonical, type=UTF8"`
+	LastModified    string `parquet:"name=last_modified, type=UTF8"`
+	ArchivePath     string `parquet:"name=archive_path, type=UTF8"`
+	ArchiveHead     string `parquet:"name=archive_head, type=UTF8"`
+	RequestHeaders  string `parquet:"name=request_headers, type=UTF8"`
+	Redirects       string `parquet:"name=redirects, type=UTF8"`
+	Extracted       string `parquet:"name=extracted, type=UTF8"`
+	Links           string `parquet:"name=links, type=UTF8"`
+	Analysis        string `parquet:"name=analysis, type=UTF8"`
+}
+
+type parquetWriter struct {
+	pw *parquetwriter.ParquetWriter
+}
+
+func newParquetWriter(w io.Writer) (pw *parquetWriter, err error) {
+	writer, err := parquetwriter.NewParquetWriter(parquetsource.NewWriterFile(w), new(parquetRecord), 1)
+	if err != nil {
+		err = errors.Wrap(err, "could not start parquet file")
+		return
+	}
+	writer.CompressionType = parquet.CompressionCodec_SNAPPY
+	pw = &parquetWriter{pw: writer}
+	return
+}
+
+func (pw *parquetWriter) write(r ExportRecord) error {
+	asJSON := func(v interface{}) string {
+		b, _ := json.Marshal(v)
+		if string(b) == "null" {
+			return ""
+		}
+		return string(b)
+	}
+	var fetchedAt int64
+	if !r.FetchedAt.IsZero() {
+		fetchedAt = r.FetchedAt.UnixNano() / int64(time.Millisecond)
+	}
+	return pw.pw.Write(parquetRecord{
+		State:           r.State,
+		URL:             r.URL,
+		StatusCode:      int32(r.StatusCode),
+		FetchedAt:       fetchedAt,
+		FetchMillis:     r.FetchMillis,
+		Proxy:           r.Proxy,
+		ContentType:     r.ContentType,
+		ContentEncoding: r.ContentEncoding,
+		Charset:         r.Charset,
+		TransferSize:    r.TransferSize,
+		DecodedSize:     r.DecodedSize,
+		Truncated:       r.Truncated,
+		NoIndex:         r.NoIndex,
+		NoFollow:        r.NoFollow,
+		Reason:          r.Reason,
+		Error:           r.Error,
+		Checked:         r.Checked,
+		Canonical:       r.Canonical,
+		LastModified:    r.LastModified,
+		ArchivePath:     r.ArchivePath,
+		ArchiveHead:     r.ArchiveHead,
+		RequestHeaders:  asJSON(r.RequestHeaders),
+		Redirects:       asJSON(r.Redirects),
+		Extracted:       asJSON(r.Extracted),
+		Links:           asJSON(r.Links),
+		Analysis:        asJSON(r.Analysis),
+	})
+}
+
+func (pw *parquetWriter) close() error {
+	return pw.pw.WriteStop()
+}
@@ -0,0 +1,92 @@
+package crawdad
+
+import (
+	"bytes"
+	"encoding/json"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/xitongsys/parquet-go-source/buffer"
+	"github.com/xitongsys/parquet-go/reader"
+)
+
+func TestExport(t *testing.T) {
+	crawl, err := New()
+	assert.Nil(t, err)
+	crawl.RedisPort = "6377"
+	err = crawl.Init(Settings{BaseURL: "https://example.com"})
+	assert.Nil(t, err)
+	assert.Nil(t, crawl.Flush())
+
+	fetchedAt := time.Date(2019, 5, 12, 16, 3, 11, 0, time.UTC)
+	for _, r := range []Record{
+		{URL: "https://example.com/", StatusCode: 200, FetchedAt: fetchedAt, ContentType: "text/html",
+			Extracted:    map[string]json.RawMessage{"pluck": json.RawMessage(`{"title":"Hats, and more"}`)},
+			Canonical:    "https://example.com/",
+			LastModified: "Sun, 12 May 2019 16:03:11 GMT",
+			Analysis:     &Analysis{InDegree: 2}},
+		{URL: "https://example.com/old", StatusCode: 200, FetchedAt: fetchedAt.Add(-48 * time.Hour)},
+		{URL: "https://other.example.com/", StatusCode: 200, FetchedAt: fetchedAt},
+	} {
+		b, _ := json.Marshal(r)
+		assert.Nil(t, crawl.done.Set(r.URL, string(b), 0).Err())
+	}
+	assert.Nil(t, crawl.todo.Set("https://example.com/next", "", 0).Err())
+
+	var out bytes.Buffer
+	err = crawl.Export(&out, "jsonl", ExportFilter{
+		States: []string{"done", "todo"},
+		Host:   "example.com",
+		Since:  fetchedAt.Add(-time.Hour),
+	}, nil)
+	assert.Nil(t, err)
+	lines := strings.Split(strings.TrimSpace(out.String()), "\n")
+	assert.Equal(t, 1, len(lines))
+	var r ExportRecord
+	assert.Nil(t, json.Unmarshal([]byte(lines[0]), &r))
+	assert.Equal(t, "done", r.State)
+	assert.Equal(t, "https://example.com/", r.URL)
+
+	// todo URLs have not been fetched, so only a time filter drops them
+	out.Reset()
+	err = crawl.Export(&out, "csv", ExportFilter{States: []string{"todo", "done"}, Host: "example.com"},
+		[]string{"state", "url", "status_code", "extracted.pluck.title"})
+	assert.Nil(t, err)
+	lines = strings.Split(strings.TrimSpace(out.String()), "\n")
+	assert.Equal(t, 4, len(lines))
+	assert.Equal(t, "state,url,status_code,extracted.pluck.title", lines[0])
+	assert.Equal(t, "todo,https://example.com/next,0,", lines[1])
+	assert.Contains(t, lines, `done,https://example.com/,200,"Hats, and more"`)
+
+	out.Reset()
+	err = crawl.Export(&out, "parquet", ExportFilter{}, nil)
+	assert.Nil(t, err)
+	f, err := buffer.NewBufferFile(out.Bytes())
+	assert.Nil(t, err)
+	pr, err := reader.NewParquetReader(f, new(parquetRecord), 1)
+	assert.Nil(t, err)
+	assert.Equal(t, int64(3), pr.GetNumRows())
+	rows := make([]parquetRecord, 3)
+	assert.Nil(t, pr.Read(&rows))
+	pr.ReadStop()
+	found := false
+	for _, row := range rows {
+		if row.URL == "https://example.com/" {
+			found = true
+			assert.Equal(t, "done", row.State)
+			assert.Equal(t, int32(200), row.StatusCode)
+			assert.Equal(t, fetchedAt.Unix()*1000, row.FetchedAt)
+			assert.Equal(t, `{"pluck":{"title":"Hats, and more"}}`, row.Extracted)
+			assert.Equal(t, "https://example.com/", row.Canonical)
+			assert.Equal(t, "Sun, 12 May 2019 16:03:11 GMT", row.LastModified)
+			assert.Contains(t, row.Analysis, `"in_degree":2`)
+		}
+	}
+	assert.True(t, found)
+
+	assert.NotNil(t, crawl.Export(&out, "xml", ExportFilter{}, nil))
+	assert.NotNil(t, crawl.Export(&out, "jsonl", ExportFilter{States: []string{"lost"}}, nil))
+	assert.Nil(t, crawl.Flush())
+}
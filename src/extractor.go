@@ -0,0 +1,131 @@
+package crawdad
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"net/http"
+
+	"github.com/pkg/errors"
+	"github.com/schollz/pluck/pluck"
+)
+
+// Extractor gets data out of a page. Each extractor saves its data in its
+// own section of the Extracted data of a record. Extract is called by all
+// the workers at once, so it must be safe for concurrent use.
+type Extractor interface {
+	// Name is the section the data is saved in
+	Name() string
+	// Extract returns the data of a page as JSON, or nil if there is none.
+	// The body is UTF-8 for text content types.
+	Extract(url string, header http.Header, body []byte) (data json.RawMessage, err error)
+}
+
+// extractors returns the extractors turned on in the settings, followed by
+// the ones given to the crawler
+func (c *Crawler) extractors() (extractors []Extractor, err error) {
+	if c.Settings.PluckConfig != "" {
+		var e *pluckExtractor
+		e, err = newPluckExtractor(c.Settings.PluckConfig)
+		if err != nil {
+			return
+		}
+		extractors = append(extractors, e)
+	}
+	fields, err := compileFields(c.Settings.Fields)
+	if err != nil {
+		return
+	}
+	if len(fields) > 0 {
+		extractors = append(extractors, fieldsExtractor(fields))
+	}
+	if c.Settings.StructuredData {
+		extractors = append(extractors, structuredDataExtractor{})
+	}
+	content, err := compileContent(c.Settings.Content)
+	if err != nil {
+		return
+	}
+	if content != nil {
+		extractors = append(extractors, content)
+	}
+	extractors = append(extractors, c.Extractors...)
+
+	names := make(map[string]bool)
+	for _, e := range extractors {
+		if e.Name() == "" {
+			return nil, errors.New("extractor needs a name")
+		}
+		if names[e.Name()] {
+			return nil, errors.New("there are two extractors named '" + e.Name() + "'")
+		}
+		names[e.Name()] = true
+	}
+	return
+}
+
+// extract runs every extractor on a page
+func (c *Crawler) extract(url string, header http.Header, body []byte) (extracted map[string]json.RawMessage, err error) {
+	for _, e := range c.extractorList {
+		var data json.RawMessage
+		data, err = e.Extract(url, header, body)
+		if err != nil {
+			err = errors.Wrap(err, "could not extract "+e.Name()+" from "+url)
+			return
+		}
+		if len(data) == 0 {
+			continue
+		}
+		if extracted == nil {
+			extracted = make(map[string]json.RawMessage)
+		}
+		extracted[e.Name()] = data
+	}
+	return
+}
+
+// pluckExtractor plucks data with github.com/schollz/pluck
+type pluckExtractor struct {
+	configs []pluck.Config
+}
+
+// newPluckExtractor parses a pluck TOML config
+func newPluckExtractor(config string) (e *pluckExtractor, err error) {
+	plucker, err := pluck.New()
+	if err != nil {
+		return
+	}
+	err = plucker.LoadFromString(config)
+	if err != nil {
+		err = errors.Wrap(err, "could not load pluck config")
+		return
+	}
+	e = &pluckExtractor{configs: plucker.Configuration()}
+	if len(e.configs) == 0 {
+		err = errors.New("pluck config has nothing to pluck")
+	}
+	return
+}
+
+func (e *pluckExtractor) Name() string {
+	return "pluck"
+}
+
+func (e *pluckExtractor) Extract(url string, header http.Header, body []byte) (data json.RawMessage, err error) {
+	// a plucker keeps what it captured, so each page gets its own
+	plucker, err := pluck.New()
+	if err != nil {
+		return
+	}
+	for _, config := range e.configs {
+		plucker.Add(config)
+	}
+	err = plucker.Pluck(bufio.NewReader(bytes.NewReader(body)))
+	if err != nil {
+		return
+	}
+	if result := plucker.ResultJSON(); result != "" {
+		data = json.RawMessage(result)
+	}
+	return
+}
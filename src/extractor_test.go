@@ -0,0 +1,65 @@
+package crawdad
+
+import (
+	"encoding/json"
+	"net/http"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+type statusExtractor struct{}
+
+func (statusExtractor) Name() string {
+	return "server"
+}
+
+func (statusExtractor) Extract(url string, header http.Header, body []byte) (json.RawMessage, error) {
+	if header.Get("Server") == "" {
+		return nil, nil
+	}
+	return json.Marshal(header.Get("Server"))
+}
+
+func TestExtractors(t *testing.T) {
+	c := &Crawler{
+		Settings: Settings{
+			PluckConfig: `[[pluck]]
+name = "title"
+activators = ["<title>"]
+deactivator = "</title>"
+limit = 1`,
+			StructuredData: true,
+		},
+		Extractors: []Extractor{statusExtractor{}},
+	}
+	var err error
+	c.extractorList, err = c.extractors()
+	assert.Nil(t, err)
+	assert.Equal(t, 3, len(c.extractorList))
+
+	body := []byte(`<html><head><title>Hats</title><meta property="og:title" content="Hats"></head></html>`)
+	header := http.Header{"Server": []string{"nginx"}}
+	// the same compiled pluck config is used for every page
+	for i := 0; i < 2; i++ {
+		extracted, err := c.extract("https://example.com/", header, body)
+		assert.Nil(t, err)
+		assert.Equal(t, `{"title":"Hats"}`, string(extracted["pluck"]))
+		assert.Equal(t, `{"opengraph":{"title":"Hats"}}`, string(extracted["structured_data"]))
+		assert.Equal(t, `"nginx"`, string(extracted["server"]))
+	}
+
+	// sections are left out if nothing was found
+	extracted, err := c.extract("https://example.com/", http.Header{}, []byte("<html></html>"))
+	assert.Nil(t, err)
+	assert.Nil(t, extracted)
+
+	c.Extractors = []Extractor{statusExtractor{}, statusExtractor{}}
+	_, err = c.extractors()
+	assert.NotNil(t, err)
+
+	c.Extractors = nil
+	c.Settings.PluckConfig = "[[pluck]\nname = "
+	_, err = c.extractors()
+	assert.NotNil(t, err)
+}
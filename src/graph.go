@@ -0,0 +1,182 @@
+package crawdad
+
+import (
+	"bufio"
+	"encoding/csv"
+	"encoding/xml"
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+
+	"github.com/pkg/errors"
+	log "github.com/schollz/logger"
+)
+
+// GraphFormats are the formats the link graph can be exported as
+var GraphFormats = []string{"csv", "graphml", "dot"}
+
+// edgeWriter writes the links of the graph one at a time
+type edgeWriter interface {
+	write(from string, link Link) error
+	close() error
+}
+
+// ExportGraph streams the links of the done URLs that pass the filter to w,
+// as a "csv" edge list, "graphml" or Graphviz "dot". The states of the filter
+// are not used, only done URLs of the site have links.
+func (c *Crawler) ExportGraph(w io.Writer, format string, filter ExportFilter) (err error) {
+	var ew edgeWriter
+	switch format {
+	case "csv":
+		ew, err = newCSVEdgeWriter(w)
+	case "graphml":
+		ew, err = newGraphMLWriter(w)
+	case "dot":
+		ew, err = newDOTWriter(w)
+	default:
+		err = errors.New("unknown graph format '" + format + "', use " + strings.Join(GraphFormats, ", "))
+	}
+	if err != nil {
+		return
+	}
+	var numPages, numLinks int
+	filter.States = []string{"done"}
+	err = c.exportRecords(filter, func(r ExportRecord) (err error) {
+		// links to other sites that were only checked
+		if !c.inScope(r.URL) {
+			return
+		}
+		numPages++
+		for _, link := range r.Links {
+			err = ew.write(r.URL, link)
+			if err != nil {
+				return
+			}
+			numLinks++
+		}
+		return
+	})
+	if errClose := ew.close(); err == nil {
+		err = errClose
+	}
+	log.Infof("exported %d links from %d URLs", numLinks, numPages)
+	return
+}
+
+type csvEdgeWriter struct {
+	w *csv.Writer
+}
+
+func newCSVEdgeWriter(w io.Writer) (ew *csvEdgeWriter, err error) {
+	ew = &csvEdgeWriter{w: csv.NewWriter(w)}
+	err = ew.w.Write([]string{"from", "to", "source", "rel", "text", "in_scope"})
+	return
+}
+
+func (ew *csvEdgeWriter) write(from string, link Link) error {
+	return ew.w.Write([]string{from, link.URL, link.Source, link.Rel, link.Text, strconv.FormatBool(link.InScope)})
+}
+
+func (ew *csvEdgeWriter) close() error {
+	ew.w.Flush()
+	return ew.w.Error()
+}
+
+// graphMLWriter writes each node the first time it is part of an edge, which
+// GraphML allows as nodes and edges can come in any order
+type graphMLWriter struct {
+	w     *bufio.Writer
+	nodes map[string]bool
+}
+
+const graphMLHeader = `<?xml version="1.0" encoding="UTF-8"?>
+<graphml xmlns="http://graphml.graphdrawing.org/xmlns">
+  <key id="text" for="edge" attr.name="text" attr.type="string"/>
+  <key id="rel" for="edge" attr.name="rel" attr.type="string"/>
+  <key id="source" for="edge" attr.name="source" attr.type="string"/>
+  <key id="in_scope" for="edge" attr.name="in_scope" attr.type="boolean"/>
+  <graph id="crawdad" edgedefault="directed">
+`
+
+func newGraphMLWriter(w io.Writer) (ew *graphMLWriter, err error) {
+	ew = &graphMLWriter{w: bufio.NewWriter(w), nodes: make(map[string]bool)}
+	_, err = ew.w.WriteString(graphMLHeader)
+	return
+}
+
+func (ew *graphMLWriter) write(from string, link Link) (err error) {
+	for _, node := range []string{from, link.URL} {
+		if ew.nodes[node] {
+			continue
+		}
+		ew.nodes[node] = true
+		fmt.Fprintf(ew.w, "    <node id=\"%s\"/>\n", xmlEscape(node))
+	}
+	fmt.Fprintf(ew.w, "    <edge source=\"%s\" target=\"%s\">\n", xmlEscape(from), xmlEscape(link.URL))
+	for _, data := range [][2]string{
+		{"text", link.Text},
+		{"rel", link.Rel},
+		{"source", link.Source},
+		{"in_scope", strconv.FormatBool(link.InScope)},
+	} {
+		if data[1] == "" {
+			continue
+		}
+		fmt.Fprintf(ew.w, "      <data key=\"%s\">%s</data>\n", data[0], xmlEscape(data[1]))
+	}
+	_, err = ew.w.WriteString("    </edge>\n")
+	return
+}
+
+func (ew *graphMLWriter) close() (err error) {
+	_, err = ew.w.WriteString("  </graph>\n</graphml>\n")
+	if err != nil {
+		return
+	}
+	return ew.w.Flush()
+}
+
+func xmlEscape(s string) string {
+	var b strings.Builder
+	xml.EscapeText(&b, []byte(s))
+	return b.String()
+}
+
+type dotWriter struct {
+	w *bufio.Writer
+}
+
+func newDOTWriter(w io.Writer) (ew *dotWriter, err error) {
+	ew = &dotWriter{w: bufio.NewWriter(w)}
+	_, err = ew.w.WriteString("digraph crawdad {\n")
+	return
+}
+
+func (ew *dotWriter) write(from string, link Link) (err error) {
+	attrs := []string{"source=" + dotQuote(link.Source), "in_scope=" + strconv.FormatBool(link.InScope)}
+	if link.Text != "" {
+		attrs = append(attrs, "label="+dotQuote(link.Text))
+	}
+	if link.Rel != "" {
+		attrs = append(attrs, "rel="+dotQuote(link.Rel))
+	}
+	_, err = fmt.Fprintf(ew.w, "  %s -> %s [%s];\n", dotQuote(from), dotQuote(link.URL), strings.Join(attrs, ", "))
+	return
+}
+
+func (ew *dotWriter) close() (err error) {
+	_, err = ew.w.WriteString("}\n")
+	if err != nil {
+		return
+	}
+	return ew.w.Flush()
+}
+
+// dotQuote makes a quoted DOT ID
+func dotQuote(s string) string {
+	s = strings.Replace(s, `\`, `\\`, -1)
+	s = strings.Replace(s, `"`, `\"`, -1)
+	s = strings.Replace(s, "\n", `\n`, -1)
+	return `"` + s + `"`
+}
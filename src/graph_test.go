@@ -0,0 +1,73 @@
+package crawdad
+
+import (
+	"bytes"
+	"encoding/json"
+	"encoding/xml"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestExportGraph(t *testing.T) {
+	crawl, err := New()
+	assert.Nil(t, err)
+	crawl.RedisPort = "6377"
+	err = crawl.Init(Settings{BaseURL: "https://example.com", KeywordsToExclude: []string{"/private"}})
+	assert.Nil(t, err)
+	assert.Nil(t, crawl.Flush())
+
+	// every link is kept, only the ones in scope are followed
+	record := Record{URL: "https://example.com/"}
+	candidates, err := crawl.processBody(&record, record.URL, nil, "text/html", []byte(`<html><body>
+		<a href="/about">About "us"</a>
+		<a href="/private/page">Private</a>
+		<a href="https://other.example.com/" rel="nofollow">Other</a>
+		<a href="/about#team">Team</a>
+	</body></html>`))
+	assert.Nil(t, err)
+	assert.Equal(t, []string{"https://example.com/about"}, candidates)
+	assert.Equal(t, []Link{
+		{URL: "https://example.com/about", Source: "a", Text: `About "us"`, InScope: true},
+		{URL: "https://example.com/private/page", Source: "a", Text: "Private"},
+		{URL: "https://other.example.com/", Source: "a", Rel: "nofollow", Text: "Other"},
+	}, record.Links)
+	b, _ := json.Marshal(record)
+	assert.Nil(t, crawl.done.Set(record.URL, string(b), 0).Err())
+	// links to other sites that were checked are not pages of the site
+	b, _ = json.Marshal(Record{URL: "https://other.example.com/", StatusCode: 200, Checked: true})
+	assert.Nil(t, crawl.done.Set("https://other.example.com/", string(b), 0).Err())
+
+	var out bytes.Buffer
+	assert.Nil(t, crawl.ExportGraph(&out, "csv", ExportFilter{}))
+	lines := strings.Split(strings.TrimSpace(out.String()), "\n")
+	assert.Equal(t, 4, len(lines))
+	assert.Equal(t, "from,to,source,rel,text,in_scope", lines[0])
+	assert.Equal(t, `https://example.com/,https://example.com/about,a,,"About ""us""",true`, lines[1])
+
+	out.Reset()
+	assert.Nil(t, crawl.ExportGraph(&out, "graphml", ExportFilter{}))
+	var graph struct {
+		Nodes []struct {
+			ID string `xml:"id,attr"`
+		} `xml:"graph>node"`
+		Edges []struct {
+			Source string `xml:"source,attr"`
+			Target string `xml:"target,attr"`
+		} `xml:"graph>edge"`
+	}
+	assert.Nil(t, xml.Unmarshal(out.Bytes(), &graph))
+	assert.Equal(t, 4, len(graph.Nodes))
+	assert.Equal(t, 3, len(graph.Edges))
+	assert.Equal(t, "https://example.com/", graph.Edges[2].Source)
+	assert.Equal(t, "https://other.example.com/", graph.Edges[2].Target)
+
+	out.Reset()
+	assert.Nil(t, crawl.ExportGraph(&out, "dot", ExportFilter{}))
+	assert.Contains(t, out.String(), `"https://example.com/" -> "https://example.com/about" [source="a", in_scope=true, label="About \"us\""];`)
+	assert.True(t, strings.HasPrefix(out.String(), "digraph crawdad {\n"))
+
+	assert.NotNil(t, crawl.ExportGraph(&out, "gexf", ExportFilter{}))
+	assert.Nil(t, crawl.Flush())
+}
@@ -0,0 +1,105 @@
+package crawdad
+
+import (
+	"net/http"
+	"sync/atomic"
+
+	"github.com/pkg/errors"
+)
+
+// redactedHeaders are not saved in records because they carry credentials
+var redactedHeaders = []string{"Authorization", "Proxy-Authorization", "Cookie"}
+
+// headerProfile returns the name of the header profile in use
+func (c *Crawler) headerProfile() string {
+	if c.HeaderProfile != "" {
+		return c.HeaderProfile
+	}
+	return c.Settings.HeaderProfile
+}
+
+// checkHeaderProfile makes sure the selected header profile exists
+func (c *Crawler) checkHeaderProfile() (err error) {
+	profile := c.headerProfile()
+	if profile == "" {
+		return
+	}
+	if _, ok := c.Settings.HeaderProfiles[profile]; !ok {
+		err = errors.New("header profile '" + profile + "' does not exist")
+	}
+	return
+}
+
+// nextUserAgent rotates through the list of user agents
+func (c *Crawler) nextUserAgent() string {
+	if c.UserAgent != "" {
+		return c.UserAgent
+	}
+	if len(c.Settings.UserAgents) == 0 {
+		return ""
+	}
+	i := atomic.AddInt64(&c.numUserAgent, 1)
+	return c.Settings.UserAgents[int(i)%len(c.Settings.UserAgents)]
+}
+
+// setHeaders adds the configured headers to the request and returns the
+// headers that will be sent. Any of them can carry credentials, so requests
+// to other hosts, like checked links and sitemaps, only get the user agent.
+func (c *Crawler) setHeaders(req *http.Request) (sent map[string]string) {
+	sameHost := c.isSameHost(req.URL)
+	if sameHost {
+		for name, value := range c.Settings.Headers {
+			req.Header.Set(name, value)
+		}
+		for name, value := range c.Settings.HeaderProfiles[c.headerProfile()] {
+			req.Header.Set(name, value)
+		}
+	}
+	if userAgent := c.nextUserAgent(); userAgent != "" {
+		req.Header.Set("User-Agent", userAgent)
+	}
+	if c.Cookie != "" && sameHost {
+		req.Header.Set("Cookie", c.Cookie)
+	}
+	req.Header.Set("Accept-Encoding", acceptEncoding)
+	c.authorize(req)
+
+	sent = make(map[string]string)
+	for name := range req.Header {
+		sent[name] = req.Header.Get(name)
+	}
+	return redactHeaders(sent)
+}
+
+// redactHeaders returns a copy of the headers without the values of the
+// ones that carry credentials
+func redactHeaders(headers map[string]string) (redacted map[string]string) {
+	if headers == nil {
+		return
+	}
+	redacted = make(map[string]string, len(headers))
+	for name, value := range headers {
+		for _, secret := range redactedHeaders {
+			if http.CanonicalHeaderKey(name) == secret {
+				value = "[redacted]"
+				break
+			}
+		}
+		redacted[name] = value
+	}
+	return
+}
+
+// redacted is a copy of the settings that can be logged
+func (s Settings) redacted() Settings {
+	s.Auth = s.Auth.redacted()
+	s.Headers = redactHeaders(s.Headers)
+	if s.HeaderProfiles != nil {
+		profiles := make(map[string]map[string]string, len(s.HeaderProfiles))
+		for name, headers := range s.HeaderProfiles {
+			profiles[name] = redactHeaders(headers)
+		}
+		s.HeaderProfiles = profiles
+	}
+	return s
+}
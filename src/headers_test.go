@@ -0,0 +1,70 @@
+package crawdad
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestSetHeaders(t *testing.T) {
+	c, err := New()
+	assert.Nil(t, err)
+	c.Settings.BaseURL = "http://example.com"
+	c.Cookie = "session=abc"
+	c.Settings.Headers = map[string]string{"Accept-Language": "en", "Authorization": "Bearer abc"}
+	c.Settings.HeaderProfiles = map[string]map[string]string{
+		"german": {"Accept-Language": "de"},
+	}
+	c.Settings.UserAgents = []string{"one", "two"}
+	assert.Nil(t, c.checkHeaderProfile())
+
+	req, _ := http.NewRequest("GET", "http://example.com", nil)
+	sent := c.setHeaders(req)
+	assert.Equal(t, "en", req.Header.Get("Accept-Language"))
+	assert.Equal(t, "Bearer abc", req.Header.Get("Authorization"))
+	assert.Equal(t, "[redacted]", sent["Authorization"])
+	assert.Equal(t, acceptEncoding, sent["Accept-Encoding"])
+	firstAgent := sent["User-Agent"]
+
+	// profiles override the base headers and user agents rotate
+	c.HeaderProfile = "german"
+	req, _ = http.NewRequest("GET", "http://example.com", nil)
+	sent = c.setHeaders(req)
+	assert.Equal(t, "de", sent["Accept-Language"])
+	assert.NotEqual(t, firstAgent, sent["User-Agent"])
+
+	// the instance user agent is always used
+	c.UserAgent = "crawdad"
+	req, _ = http.NewRequest("GET", "http://example.com", nil)
+	assert.Equal(t, "crawdad", c.setHeaders(req)["User-Agent"])
+
+	// other hosts get nothing that could carry credentials
+	req, _ = http.NewRequest("GET", "http://other.com", nil)
+	sent = c.setHeaders(req)
+	assert.Equal(t, map[string]string{"User-Agent": "crawdad", "Accept-Encoding": acceptEncoding}, sent)
+
+	c.HeaderProfile = "missing"
+	assert.NotNil(t, c.checkHeaderProfile())
+}
+
+func TestRedactedSettings(t *testing.T) {
+	settings := Settings{
+		Headers:        map[string]string{"authorization": "Bearer abc", "Accept-Language": "en"},
+		HeaderProfiles: map[string]map[string]string{"logged-in": {"Cookie": "session=abc"}},
+		Auth:           AuthConfig{Type: "form", Password: "secret", Token: "abc", Fields: map[string]string{"pin": "1234"}},
+	}
+	b, err := json.Marshal(settings.redacted())
+	assert.Nil(t, err)
+	for _, secret := range []string{"secret", "abc", "1234"} {
+		assert.NotContains(t, string(b), secret)
+	}
+	assert.Contains(t, string(b), `"Accept-Language":"en"`)
+	assert.NotContains(t, fmt.Sprintf("%v", settings.redacted()), "abc")
+	// the settings themselves are unchanged
+	assert.Equal(t, "Bearer abc", settings.Headers["authorization"])
+	assert.Equal(t, "secret", settings.Auth.Password)
+	assert.Equal(t, "1234", settings.Auth.Fields["pin"])
+}
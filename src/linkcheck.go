@@ -0,0 +1,157 @@
+package crawdad
+
+import (
+	"encoding/csv"
+	"encoding/json"
+	"io"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/pkg/errors"
+	log "github.com/schollz/logger"
+)
+
+// BrokenLink is a trashed URL and the pages that link to it
+type BrokenLink struct {
+	URL        string     `json:"url"`
+	StatusCode int        `json:"status_code"`
+	Reason     string     `json:"reason,omitempty"`
+	Error      string     `json:"error,omitempty"`
+	Referrers  []Referrer `json:"referrers"`
+}
+
+// Referrer is a page that links to a URL
+type Referrer struct {
+	URL  string `json:"url"`
+	Text string `json:"text,omitempty"`
+}
+
+// isExternal is true for web links outside of the base URL
+func (c *Crawler) isExternal(link string) bool {
+	return !c.inScope(link) && (strings.HasPrefix(link, "http://") || strings.HasPrefix(link, "https://"))
+}
+
+// checkLink makes sure a link works without downloading it. It asks with HEAD
+// first and then with GET, as some servers do not answer HEAD properly, and
+// reads none of the body. Links that do not work are moved to the trash.
+func (c *Crawler) checkLink(url string) (record Record, err error) {
+	record.URL = url
+	record.Checked = true
+	var resp *http.Response
+	for _, method := range []string{"HEAD", "GET"} {
+		var req *http.Request
+		req, err = http.NewRequest(method, url, nil)
+		if err != nil {
+			err = errors.Wrap(err, "could not make New Request for '"+url+"'")
+			return
+		}
+		record.RequestHeaders = c.setHeaders(req)
+		var proxy *proxyEntry
+		req, proxy = c.withProxy(req)
+		record.FetchedAt = time.Now().UTC()
+		resp, err = c.client.Do(req)
+		c.metrics.observeResponse(url, resp, time.Since(record.FetchedAt))
+		if err != nil {
+			if proxy != nil {
+				c.proxies.markFailed(proxy, err)
+			}
+			continue
+		}
+		resp.Body.Close()
+		if proxy != nil {
+			c.proxies.markHealthy(proxy)
+			record.Proxy = proxy.name
+		}
+		if resp.StatusCode < 400 {
+			break
+		}
+	}
+	if err != nil {
+		log.Debugf("could not check %s: %s", url, err.Error())
+		record.Reason = "error"
+		record.Error = err.Error()
+		c.moveToTrash(record)
+		err = errMoved
+		return
+	}
+	record.StatusCode = resp.StatusCode
+	record.Redirects = redirectChain(resp)
+	record.ContentType = mediaType(resp.Header.Get("Content-Type"))
+	if resp.StatusCode >= 400 {
+		record.Reason = "status"
+		c.moveToTrash(record)
+		err = errMoved
+	}
+	return
+}
+
+// BrokenLinks returns the trashed URLs, each with the done pages that link to
+// it and the anchor text of the links
+func (c *Crawler) BrokenLinks() (broken []BrokenLink, err error) {
+	index := make(map[string]int)
+	err = c.exportRecords(ExportFilter{States: []string{"trash"}}, func(r ExportRecord) error {
+		index[r.URL] = len(broken)
+		broken = append(broken, BrokenLink{
+			URL:        r.URL,
+			StatusCode: r.StatusCode,
+			Reason:     r.Reason,
+			Error:      r.Error,
+			Referrers:  []Referrer{},
+		})
+		return nil
+	})
+	if err != nil || len(broken) == 0 {
+		return
+	}
+	err = c.exportRecords(ExportFilter{}, func(r ExportRecord) error {
+		for _, link := range r.Links {
+			if i, ok := index[link.URL]; ok {
+				broken[i].Referrers = append(broken[i].Referrers, Referrer{URL: r.URL, Text: link.Text})
+			}
+		}
+		return nil
+	})
+	sort.Slice(broken, func(i, j int) bool {
+		return broken[i].URL < broken[j].URL
+	})
+	return
+}
+
+// BrokenLinkReport writes the broken links to w as "csv", with a row for each
+// page that links to a broken URL, or as "jsonl"
+func (c *Crawler) BrokenLinkReport(w io.Writer, format string) (err error) {
+	if format != "csv" && format != "jsonl" {
+		return errors.New("unknown report format '" + format + "', use csv or jsonl")
+	}
+	broken, err := c.BrokenLinks()
+	if err != nil {
+		return
+	}
+	log.Infof("found %d broken links", len(broken))
+	if format == "jsonl" {
+		enc := json.NewEncoder(w)
+		for _, b := range broken {
+			err = enc.Encode(b)
+			if err != nil {
+				return
+			}
+		}
+		return
+	}
+	cw := csv.NewWriter(w)
+	cw.Write([]string{"url", "status_code", "reason", "error", "referrer", "anchor_text"})
+	for _, b := range broken {
+		row := []string{b.URL, strconv.Itoa(b.StatusCode), b.Reason, b.Error}
+		if len(b.Referrers) == 0 {
+			cw.Write(append(row, "", ""))
+		}
+		for _, referrer := range b.Referrers {
+			cw.Write(append(row, referrer.URL, referrer.Text))
+		}
+	}
+	cw.Flush()
+	return cw.Error()
+}
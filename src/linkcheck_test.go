@@ -0,0 +1,125 @@
+package crawdad
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestCheckLinks(t *testing.T) {
+	var external *httptest.Server
+	var leaked []string
+	external = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		for _, name := range []string{"Cookie", "Authorization", "X-Api-Key"} {
+			if r.Header.Get(name) != "" {
+				leaked = append(leaked, name)
+			}
+		}
+		switch {
+		case r.URL.Path == "/ok" && r.Method == "HEAD":
+			w.WriteHeader(405)
+		case r.URL.Path == "/ok":
+			w.Header().Set("Content-Type", "text/html")
+			fmt.Fprint(w, `<a href="/never">never followed</a>`)
+		default:
+			http.NotFound(w, r)
+		}
+	}))
+	defer external.Close()
+	site := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		assert.Equal(t, "session=abc", r.Header.Get("Cookie"))
+		assert.Equal(t, "abc", r.Header.Get("X-Api-Key"))
+		if r.URL.Path == "/reset" {
+			// the connection is closed without a response
+			conn, _, _ := w.(http.Hijacker).Hijack()
+			conn.Close()
+			return
+		}
+		if r.URL.Path != "/" {
+			http.NotFound(w, r)
+			return
+		}
+		w.Header().Set("Content-Type", "text/html")
+		fmt.Fprintf(w, `<a href="/missing">Missing page</a> <a href="/reset">Reset</a> <a href="%[1]s/ok">Fine</a> <a href="%[1]s/gone">Gone</a>`, external.URL)
+	}))
+	defer site.Close()
+
+	crawl, err := New()
+	assert.Nil(t, err)
+	crawl.RedisPort = "6377"
+	crawl.Cookie = "session=abc"
+	err = crawl.Init(Settings{
+		BaseURL:    site.URL + "/",
+		CheckLinks: true,
+		Headers:    map[string]string{"Authorization": "Bearer abc", "X-Api-Key": "abc"},
+	})
+	assert.Nil(t, err)
+	assert.Nil(t, crawl.Flush())
+
+	// a worker goes through the queue
+	queue := []string{site.URL + "/"}
+	for len(queue) > 0 {
+		url := queue[0]
+		queue = queue[1:]
+		links, record, err := crawl.scrapeLinks(url)
+		if err == errMoved {
+			continue
+		}
+		if failure, ok := err.(retryError); ok {
+			if crawl.retry(url, failure) {
+				queue = append(queue, url)
+			}
+			continue
+		}
+		assert.Nil(t, err)
+		b, _ := json.Marshal(record)
+		assert.Nil(t, crawl.done.Set(url, string(b), 0).Err())
+		queue = append(queue, links...)
+	}
+	assert.Equal(t, int64(2), crawl.done.DbSize().Val())
+	val, err := crawl.done.Get(external.URL + "/ok").Result()
+	assert.Nil(t, err)
+	var record Record
+	assert.Nil(t, json.Unmarshal([]byte(val), &record))
+	assert.Equal(t, 200, record.StatusCode)
+	assert.Empty(t, record.Links)
+	assert.True(t, record.Checked)
+	// the credentials of the site are not sent to other sites
+	assert.Empty(t, leaked)
+
+	broken, err := crawl.BrokenLinks()
+	assert.Nil(t, err)
+	assert.Equal(t, 3, len(broken))
+	// the servers are on random ports, so the order is not known
+	byURL := make(map[string]BrokenLink)
+	for _, link := range broken {
+		byURL[link.URL] = link
+	}
+	assert.Equal(t, BrokenLink{
+		URL:        external.URL + "/gone",
+		StatusCode: 404,
+		Reason:     "status",
+		Referrers:  []Referrer{{URL: site.URL + "/", Text: "Gone"}},
+	}, byURL[external.URL+"/gone"])
+	assert.Equal(t, 404, byURL[site.URL+"/missing"].StatusCode)
+	// pages of the site that can not be reached are trashed after a few attempts
+	reset := byURL[site.URL+"/reset"]
+	assert.Equal(t, "error", reset.Reason)
+	assert.NotEmpty(t, reset.Error)
+	assert.Equal(t, []Referrer{{URL: site.URL + "/", Text: "Reset"}}, reset.Referrers)
+
+	var report bytes.Buffer
+	assert.Nil(t, crawl.BrokenLinkReport(&report, "csv"))
+	lines := strings.Split(strings.TrimSpace(report.String()), "\n")
+	assert.Equal(t, 4, len(lines))
+	assert.Equal(t, "url,status_code,reason,error,referrer,anchor_text", lines[0])
+	assert.Contains(t, lines, site.URL+"/missing,404,status,,"+site.URL+"/,Missing page")
+	assert.NotNil(t, crawl.BrokenLinkReport(&report, "xml"))
+	assert.Nil(t, crawl.Flush())
+}
@@ -0,0 +1,253 @@
+package crawdad
+
+import (
+	"bytes"
+	"net/http"
+	"net/url"
+	"regexp"
+	"strings"
+
+	"golang.org/x/net/html"
+)
+
+// Link is a link found on a page
+type Link struct {
+	URL string `json:"url"`
+	// Source is where the link was found, one of the LinkSources
+	Source string `json:"source"`
+	Rel    string `json:"rel,omitempty"`
+	Text   string `json:"text,omitempty"`
+	// InScope is true for links the crawl follows, those under the base URL
+	// that pass the keyword filters
+	InScope bool `json:"in_scope"`
+}
+
+// LinkSources are the kinds of links that can be followed:
+//
+//	a             <a href>
+//	area          <area href>
+//	link          <link href> like rel="next" or rel="prev"
+//	canonical     <link rel="canonical">
+//	alternate     <link rel="alternate">
+//	iframe        <iframe src> and <frame src>
+//	src           src of images, scripts, video, audio, embeds and sources
+//	srcset        srcset of images and sources
+//	meta-refresh  <meta http-equiv="refresh" content="0; url=...">
+//	css           url(...) in style attributes and <style> blocks
+var LinkSources = []string{"a", "area", "link", "canonical", "alternate", "iframe", "src", "srcset", "meta-refresh", "css"}
+
+var cssURL = regexp.MustCompile(`url\(\s*['"]?([^'")\s]+)['"]?\s*\)`)
+
+// linkExtractor collects the links of a page
+type linkExtractor struct {
+	base            *url.URL
+	sources         map[string]bool
+	respectNofollow bool
+	links           []Link
+	seen            map[string]bool
+}
+
+// extractLinks returns the absolute URLs of the links of the given sources,
+// in the order they appear on the page. Links with rel="nofollow" are
+// dropped if respectNofollow is set.
+func extractLinks(body []byte, pageURL string, sources []string, respectNofollow bool) []Link {
+	e := &linkExtractor{
+		sources:         make(map[string]bool),
+		respectNofollow: respectNofollow,
+		seen:            make(map[string]bool),
+	}
+	e.base, _ = url.Parse(pageURL)
+	if len(sources) == 0 {
+		sources = []string{"a"}
+	}
+	for _, source := range sources {
+		e.sources[strings.ToLower(strings.TrimSpace(source))] = true
+	}
+
+	var anchor *Link
+	var anchorText strings.Builder
+	inStyle := false
+	z := html.NewTokenizer(bytes.NewReader(body))
+	for {
+		tt := z.Next()
+		switch tt {
+		case html.ErrorToken:
+			if anchor != nil {
+				e.addAnchor(anchor, anchorText.String())
+			}
+			return e.links
+		case html.TextToken:
+			if anchor != nil {
+				anchorText.Write(z.Text())
+			}
+			if inStyle {
+				e.addCSS(string(z.Text()))
+			}
+		case html.EndTagToken:
+			name, _ := z.TagName()
+			switch string(name) {
+			case "a":
+				if anchor != nil {
+					e.addAnchor(anchor, anchorText.String())
+					anchor = nil
+				}
+			case "style":
+				inStyle = false
+			}
+		case html.StartTagToken, html.SelfClosingTagToken:
+			token := z.Token()
+			rel := strings.ToLower(getAttr(token, "rel"))
+			switch token.Data {
+			case "base":
+				if href := getAttr(token, "href"); href != "" && e.base != nil {
+					if base, err := e.base.Parse(href); err == nil {
+						e.base = base
+					}
+				}
+			case "a":
+				if anchor != nil {
+					e.addAnchor(anchor, anchorText.String())
+				}
+				anchor = nil
+				if href := getAttr(token, "href"); href != "" && tt == html.StartTagToken {
+					anchor = &Link{URL: href, Source: "a", Rel: rel}
+					anchorText.Reset()
+				} else if href != "" {
+					e.add(Link{URL: href, Source: "a", Rel: rel})
+				}
+			case "area":
+				e.add(Link{URL: getAttr(token, "href"), Source: "area", Rel: rel, Text: getAttr(token, "alt")})
+			case "link":
+				source := "link"
+				for _, r := range strings.Fields(rel) {
+					if r == "canonical" || r == "alternate" {
+						source = r
+					}
+				}
+				if source == "link" && (strings.Contains(rel, "stylesheet") || strings.Contains(rel, "icon")) {
+					source = "src"
+				}
+				e.add(Link{URL: getAttr(token, "href"), Source: source, Rel: rel})
+			case "iframe", "frame":
+				e.add(Link{URL: getAttr(token, "src"), Source: "iframe"})
+			case "img", "script", "source", "video", "audio", "embed", "track", "input":
+				e.add(Link{URL: getAttr(token, "src"), Source: "src"})
+				if srcset := getAttr(token, "srcset"); srcset != "" && e.sources["srcset"] {
+					for _, candidate := range strings.Split(srcset, ",") {
+						if fields := strings.Fields(candidate); len(fields) > 0 {
+							e.add(Link{URL: fields[0], Source: "srcset"})
+						}
+					}
+				}
+			case "meta":
+				if strings.EqualFold(getAttr(token, "http-equiv"), "refresh") {
+					e.add(Link{URL: refreshURL(getAttr(token, "content")), Source: "meta-refresh"})
+				}
+			case "style":
+				inStyle = tt == html.StartTagToken
+			}
+			if style := getAttr(token, "style"); style != "" {
+				e.addCSS(style)
+			}
+		}
+	}
+}
+
+func (e *linkExtractor) addAnchor(anchor *Link, text string) {
+	anchor.Text = strings.Join(strings.Fields(text), " ")
+	e.add(*anchor)
+}
+
+func (e *linkExtractor) addCSS(css string) {
+	if !e.sources["css"] {
+		return
+	}
+	for _, match := range cssURL.FindAllStringSubmatch(css, -1) {
+		e.add(Link{URL: match[1], Source: "css"})
+	}
+}
+
+// add resolves the link against the page and keeps it if its source is wanted
+func (e *linkExtractor) add(link Link) {
+	link.URL = strings.TrimSpace(link.URL)
+	if link.URL == "" || !e.sources[link.Source] || strings.HasPrefix(link.URL, "data:") {
+		return
+	}
+	if e.respectNofollow {
+		for _, r := range strings.Fields(link.Rel) {
+			if r == "nofollow" {
+				return
+			}
+		}
+	}
+	if e.base != nil {
+		resolved, err := e.base.Parse(link.URL)
+		if err != nil {
+			return
+		}
+		link.URL = resolved.String()
+	}
+	if e.seen[link.URL] {
+		return
+	}
+	e.seen[link.URL] = true
+	e.links = append(e.links, link)
+}
+
+// refreshURL gets the URL from the content of a meta refresh tag
+func refreshURL(content string) string {
+	i := strings.Index(strings.ToLower(content), "url")
+	if i < 0 {
+		return ""
+	}
+	target := strings.TrimSpace(content[i+3:])
+	if !strings.HasPrefix(target, "=") {
+		return ""
+	}
+	return strings.Trim(strings.TrimSpace(target[1:]), `'"`)
+}
+
+// headerCanonical returns the absolute URL of a Link: <...>; rel="canonical"
+// response header, which is how canonicals are given for pages that are not
+// HTML
+func headerCanonical(header http.Header, pageURL string) string {
+	base, err := url.Parse(pageURL)
+	if err != nil {
+		return ""
+	}
+	for _, value := range header["Link"] {
+		for value != "" {
+			start := strings.Index(value, "<")
+			end := strings.Index(value, ">")
+			if start < 0 || end < start {
+				break
+			}
+			target := value[start+1 : end]
+			value = value[end+1:]
+			params := value
+			if next := strings.Index(value, ","); next >= 0 {
+				params, value = value[:next], value[next+1:]
+			} else {
+				value = ""
+			}
+			for _, param := range strings.Split(params, ";") {
+				parts := strings.SplitN(strings.TrimSpace(param), "=", 2)
+				if len(parts) != 2 || !strings.EqualFold(strings.TrimSpace(parts[0]), "rel") {
+					continue
+				}
+				for _, rel := range strings.Fields(strings.Trim(strings.TrimSpace(parts[1]), `"`)) {
+					if !strings.EqualFold(rel, "canonical") {
+						continue
+					}
+					u, err := base.Parse(strings.TrimSpace(target))
+					if err != nil {
+						return ""
+					}
+					u.Fragment = ""
+					return u.String()
+				}
+			}
+		}
+	}
+	return ""
+}
@@ -0,0 +1,74 @@
+package crawdad
+
+import (
+	"net/http"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestExtractLinks(t *testing.T) {
+	page := []byte(`<html><head>
+<link rel="canonical" href="/page">
+<link rel="next" href="?p=2">
+<link rel="stylesheet" href="/style.css">
+<meta http-equiv="refresh" content="5; URL='/moved'">
+<style>body { background: url("/bg.png") }</style>
+</head><body>
+<a href="/about">About <b>us</b></a>
+<a href="http://other.com/x" rel="nofollow">other</a>
+<a href="/about">again</a>
+<map><area href="/map" alt="Map"></map>
+<iframe src="frame.html"></iframe>
+<img src="/small.jpg" srcset="/medium.jpg 2x, /large.jpg 3x">
+<div style="background-image: url(/div.png)"></div>
+<a href="data:text/plain,hi">data</a>
+</body></html>`)
+
+	links := extractLinks(page, "http://site.com/dir/index.html", nil, false)
+	assert.Equal(t, []Link{
+		{URL: "http://site.com/about", Source: "a", Text: "About us"},
+		{URL: "http://other.com/x", Source: "a", Rel: "nofollow", Text: "other"},
+	}, links)
+
+	links = extractLinks(page, "http://site.com/dir/index.html", []string{"a"}, true)
+	assert.Equal(t, 1, len(links))
+
+	links = extractLinks(page, "http://site.com/dir/index.html", LinkSources, false)
+	urls := make(map[string]string)
+	for _, link := range links {
+		urls[link.URL] = link.Source
+	}
+	assert.Equal(t, map[string]string{
+		"http://site.com/page":               "canonical",
+		"http://site.com/dir/index.html?p=2": "link",
+		"http://site.com/style.css":          "src",
+		"http://site.com/moved":              "meta-refresh",
+		"http://site.com/bg.png":             "css",
+		"http://site.com/about":              "a",
+		"http://other.com/x":                 "a",
+		"http://site.com/map":                "area",
+		"http://site.com/dir/frame.html":     "iframe",
+		"http://site.com/small.jpg":          "src",
+		"http://site.com/medium.jpg":         "srcset",
+		"http://site.com/large.jpg":          "srcset",
+		"http://site.com/div.png":            "css",
+	}, urls)
+
+	// <base> changes where relative links point
+	links = extractLinks([]byte(`<base href="http://cdn.com/"><a href="x">x</a>`), "http://site.com/", nil, false)
+	assert.Equal(t, "http://cdn.com/x", links[0].URL)
+}
+
+func TestHeaderCanonical(t *testing.T) {
+	header := http.Header{}
+	assert.Equal(t, "", headerCanonical(header, "http://site.com/doc.pdf"))
+
+	header.Add("Link", `<http://site.com/style.css>; rel="preload"; as="style", </papers/doc.pdf#top>; rel="canonical"`)
+	assert.Equal(t, "http://site.com/papers/doc.pdf", headerCanonical(header, "http://site.com/doc.pdf"))
+
+	header = http.Header{}
+	header.Add("Link", `<http://site.com/next>; rel=next`)
+	header.Add("Link", `<http://site.com/>; rel="index Canonical"`)
+	assert.Equal(t, "http://site.com/", headerCanonical(header, "http://site.com/doc.pdf"))
+}
@@ -0,0 +1,193 @@
+package crawdad
+
+import (
+	"net/http"
+	"net/url"
+	"os"
+	"strconv"
+	"time"
+
+	"github.com/go-redis/redis"
+	"github.com/pkg/errors"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+	log "github.com/schollz/logger"
+)
+
+// metrics are the Prometheus metrics of a crawler. A nil *metrics records
+// nothing, so the crawler can use it whether or not metrics are on.
+type metrics struct {
+	registry      *prometheus.Registry
+	pagesFetched  prometheus.Counter
+	bytes         *prometheus.CounterVec
+	responses     *prometheus.CounterVec
+	hostErrors    *prometheus.CounterVec
+	fetchDuration prometheus.Histogram
+	workers       prometheus.Gauge
+	workersBusy   prometheus.Gauge
+	robotsDenials *prometheus.CounterVec
+	server        *http.Server
+}
+
+// newMetrics registers the metrics of the crawler, labelled with its project
+// and node. The queue sizes are read from Redis whenever they are scraped, so
+// every node reports the whole crawl.
+func (c *Crawler) newMetrics() (m *metrics) {
+	project := c.Project
+	if project == "" {
+		if u, err := url.Parse(c.Settings.BaseURL); err == nil {
+			project = u.Hostname()
+		}
+	}
+	node := c.Node
+	if node == "" {
+		node, _ = os.Hostname()
+	}
+	m = &metrics{registry: prometheus.NewRegistry()}
+	reg := prometheus.WrapRegistererWith(prometheus.Labels{"project": project, "node": node}, m.registry)
+	reg.MustRegister(prometheus.NewGoCollector())
+	reg.MustRegister(prometheus.NewProcessCollector(prometheus.ProcessCollectorOpts{}))
+
+	for _, queue := range []struct {
+		state string
+		db    *redis.Client
+	}{
+		{"todo", c.todo},
+		{"doing", c.doing},
+		{"done", c.done},
+		{"trash", c.trash},
+		{"skipped", c.skipped},
+	} {
+		db := queue.db
+		reg.MustRegister(prometheus.NewGaugeFunc(prometheus.GaugeOpts{
+			Name:        "crawdad_queue_urls",
+			Help:        "Number of URLs in each list of the crawl.",
+			ConstLabels: prometheus.Labels{"state": queue.state},
+		}, func() float64 {
+			size, _ := db.DbSize().Result()
+			return float64(size)
+		}))
+	}
+
+	m.pagesFetched = prometheus.NewCounter(prometheus.CounterOpts{
+		Name: "crawdad_pages_fetched_total",
+		Help: "Number of pages fetched and moved to done by this node.",
+	})
+	m.bytes = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Name: "crawdad_bytes_total",
+		Help: "Bytes of bodies read, as transferred or after decoding.",
+	}, []string{"kind"})
+	m.responses = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Name: "crawdad_responses_total",
+		Help: "Responses by status code class, or 'error' for requests that got no response.",
+	}, []string{"class"})
+	m.hostErrors = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Name: "crawdad_host_errors_total",
+		Help: "Failed requests and error statuses by host.",
+	}, []string{"host"})
+	m.fetchDuration = prometheus.NewHistogram(prometheus.HistogramOpts{
+		Name:    "crawdad_fetch_duration_seconds",
+		Help:    "Time until the response headers of a request.",
+		Buckets: prometheus.ExponentialBuckets(0.05, 2, 10),
+	})
+	m.workers = prometheus.NewGauge(prometheus.GaugeOpts{
+		Name: "crawdad_workers",
+		Help: "Number of workers of this node.",
+	})
+	m.workersBusy = prometheus.NewGauge(prometheus.GaugeOpts{
+		Name: "crawdad_workers_busy",
+		Help: "Number of workers of this node that are fetching a URL.",
+	})
+	m.robotsDenials = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Name: "crawdad_robots_denials_total",
+		Help: "Pages that said noindex or nofollow in a robots meta tag or X-Robots-Tag header.",
+	}, []string{"directive"})
+	reg.MustRegister(m.pagesFetched, m.bytes, m.responses, m.hostErrors, m.fetchDuration, m.workers, m.workersBusy, m.robotsDenials)
+	m.workers.Set(float64(c.MaxNumberWorkers))
+	return
+}
+
+// serve listens on address, returning once it is listening
+func (m *metrics) serve(address string) (err error) {
+	mux := http.NewServeMux()
+	mux.Handle("/metrics", promhttp.HandlerFor(m.registry, promhttp.HandlerOpts{}))
+	m.server, err = serveHTTP(address, mux)
+	if err != nil {
+		err = errors.Wrap(err, "could not listen for metrics")
+		return
+	}
+	log.Infof("serving metrics at http://%s/metrics", m.server.Addr)
+	return
+}
+
+func (m *metrics) close() {
+	if m == nil || m.server == nil {
+		return
+	}
+	m.server.Close()
+}
+
+// observeResponse records a response, or a request that failed if resp is nil
+func (m *metrics) observeResponse(link string, resp *http.Response, took time.Duration) {
+	if m == nil {
+		return
+	}
+	class := "error"
+	if resp != nil {
+		m.fetchDuration.Observe(took.Seconds())
+		class = strconv.Itoa(resp.StatusCode/100) + "xx"
+	}
+	m.responses.WithLabelValues(class).Inc()
+	if resp == nil || resp.StatusCode >= 400 {
+		host := ""
+		if u, err := url.Parse(link); err == nil {
+			host = u.Hostname()
+		}
+		m.hostErrors.WithLabelValues(host).Inc()
+	}
+}
+
+func (m *metrics) observeBody(transferSize, decodedSize int64) {
+	if m == nil {
+		return
+	}
+	m.bytes.WithLabelValues("transfer").Add(float64(transferSize))
+	m.bytes.WithLabelValues("decoded").Add(float64(decodedSize))
+}
+
+func (m *metrics) observeRobots(record Record) {
+	if m == nil {
+		return
+	}
+	if record.NoIndex {
+		m.robotsDenials.WithLabelValues("noindex").Inc()
+	}
+	if record.NoFollow {
+		m.robotsDenials.WithLabelValues("nofollow").Inc()
+	}
+}
+
+func (m *metrics) pageFetched() {
+	if m == nil {
+		return
+	}
+	m.pagesFetched.Inc()
+}
+
+func (m *metrics) setWorkers(workers int) {
+	if m == nil {
+		return
+	}
+	m.workers.Set(float64(workers))
+}
+
+func (m *metrics) workerBusy(busy bool) {
+	if m == nil {
+		return
+	}
+	if busy {
+		m.workersBusy.Inc()
+	} else {
+		m.workersBusy.Dec()
+	}
+}
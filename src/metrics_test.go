@@ -0,0 +1,61 @@
+package crawdad
+
+import (
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestMetrics(t *testing.T) {
+	site := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/" {
+			http.NotFound(w, r)
+			return
+		}
+		w.Header().Set("Content-Type", "text/html")
+		fmt.Fprint(w, `<meta name="robots" content="noindex"><a href="/missing">Missing</a>`)
+	}))
+	defer site.Close()
+
+	crawl, err := New()
+	assert.Nil(t, err)
+	crawl.RedisPort = "6377"
+	crawl.MetricsAddress = "127.0.0.1:0"
+	crawl.Project = "shop"
+	crawl.Node = "node-1"
+	err = crawl.Init(Settings{BaseURL: site.URL + "/"})
+	assert.Nil(t, err)
+	assert.Nil(t, crawl.Flush())
+	assert.Nil(t, crawl.todo.Set("https://example.com/next", "", 0).Err())
+
+	_, _, err = crawl.scrapeLinks(site.URL + "/")
+	assert.Nil(t, err)
+	_, _, err = crawl.scrapeLinks(site.URL + "/missing")
+	assert.Equal(t, errMoved, err)
+
+	assert.Nil(t, crawl.metrics.serve("127.0.0.1:0"))
+	defer crawl.metrics.close()
+	resp, err := http.Get("http://" + crawl.metrics.server.Addr + "/metrics")
+	assert.Nil(t, err)
+	b, _ := ioutil.ReadAll(resp.Body)
+	resp.Body.Close()
+	body := string(b)
+	for _, line := range []string{
+		`crawdad_queue_urls{node="node-1",project="shop",state="todo"} 1`,
+		`crawdad_queue_urls{node="node-1",project="shop",state="trash"} 1`,
+		`crawdad_responses_total{class="2xx",node="node-1",project="shop"} 1`,
+		`crawdad_responses_total{class="4xx",node="node-1",project="shop"} 1`,
+		`crawdad_host_errors_total{host="127.0.0.1",node="node-1",project="shop"} 1`,
+		`crawdad_fetch_duration_seconds_count{node="node-1",project="shop"} 2`,
+		`crawdad_robots_denials_total{directive="noindex",node="node-1",project="shop"} 1`,
+		`crawdad_workers{node="node-1",project="shop"} 8`,
+	} {
+		assert.Contains(t, body, line)
+	}
+	assert.Contains(t, body, `crawdad_bytes_total{kind="transfer",node="node-1",project="shop"}`)
+	assert.Nil(t, crawl.Flush())
+}
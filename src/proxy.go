@@ -0,0 +1,157 @@
+package crawdad
+
+import (
+	"context"
+	"hash/fnv"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/pkg/errors"
+	log "github.com/schollz/logger"
+)
+
+// torProxyURL is the default SOCKS5 endpoint of a local Tor client
+const torProxyURL = "socks5://127.0.0.1:9050"
+
+// proxyCooldown is how long a failing proxy is skipped before it is tried again
+const proxyCooldown = 2 * time.Minute
+
+type proxyContextKey struct{}
+
+type proxyEntry struct {
+	url       *url.URL
+	name      string
+	healthy   bool
+	lastError time.Time
+}
+
+// proxyPool rotates requests through a list of proxies, either in order
+// ("round-robin") or by pinning each host to one proxy ("host")
+type proxyPool struct {
+	proxies  []*proxyEntry
+	rotation string
+	next     int
+	sync.Mutex
+}
+
+func newProxyPool(proxyURLs []string, rotation string) (p *proxyPool, err error) {
+	p = new(proxyPool)
+	switch rotation {
+	case "", "round-robin":
+		p.rotation = "round-robin"
+	case "host":
+		p.rotation = "host"
+	default:
+		err = errors.New("unknown proxy rotation '" + rotation + "', use 'round-robin' or 'host'")
+		return
+	}
+	for _, proxyURL := range proxyURLs {
+		proxyURL = strings.TrimSpace(proxyURL)
+		if proxyURL == "" {
+			continue
+		}
+		u, errParse := url.Parse(proxyURL)
+		if errParse != nil {
+			err = errors.Wrap(errParse, "could not parse proxy '"+proxyURL+"'")
+			return
+		}
+		if u.Scheme != "http" && u.Scheme != "https" && u.Scheme != "socks5" {
+			err = errors.New("proxy '" + proxyURL + "' must be http://, https:// or socks5://")
+			return
+		}
+		p.proxies = append(p.proxies, &proxyEntry{
+			url:     u,
+			name:    proxyName(u),
+			healthy: true,
+		})
+	}
+	if len(p.proxies) == 0 {
+		err = errors.New("no proxies specified")
+	}
+	return
+}
+
+// proxyName is the proxy URL without the password, so it can be logged
+func proxyName(u *url.URL) string {
+	name := u.Scheme + "://"
+	if u.User != nil {
+		name += u.User.Username() + "@"
+	}
+	return name + u.Host
+}
+
+// pick returns the proxy to use for the host
+func (p *proxyPool) pick(host string) *proxyEntry {
+	p.Lock()
+	defer p.Unlock()
+
+	var start int
+	if p.rotation == "host" {
+		// hash over every proxy, so a host keeps its proxy while others fail
+		h := fnv.New32a()
+		h.Write([]byte(host))
+		start = int(h.Sum32() % uint32(len(p.proxies)))
+	} else {
+		p.next++
+		start = p.next
+	}
+	for i := range p.proxies {
+		proxy := p.proxies[(start+i)%len(p.proxies)]
+		if proxy.healthy || time.Since(proxy.lastError) > proxyCooldown {
+			return proxy
+		}
+	}
+
+	// everything is failing, so try the one that failed longest ago
+	oldest := p.proxies[0]
+	for _, proxy := range p.proxies {
+		if proxy.lastError.Before(oldest.lastError) {
+			oldest = proxy
+		}
+	}
+	return oldest
+}
+
+func (p *proxyPool) markFailed(proxy *proxyEntry, err error) {
+	p.Lock()
+	defer p.Unlock()
+	if proxy.healthy {
+		log.Warnf("marking proxy %s unhealthy: %s", proxy.name, err.Error())
+	}
+	proxy.healthy = false
+	proxy.lastError = time.Now()
+}
+
+func (p *proxyPool) markHealthy(proxy *proxyEntry) {
+	p.Lock()
+	defer p.Unlock()
+	if !proxy.healthy {
+		log.Infof("proxy %s is healthy again", proxy.name)
+	}
+	proxy.healthy = true
+}
+
+// withProxy chooses a proxy for the request and attaches it to the request
+// context, where it is found by the transport
+func (c *Crawler) withProxy(req *http.Request) (*http.Request, *proxyEntry) {
+	if c.proxies == nil {
+		return req, nil
+	}
+	proxy := c.proxies.pick(req.URL.Host)
+	return req.WithContext(context.WithValue(req.Context(), proxyContextKey{}, proxy)), proxy
+}
+
+// proxyForRequest is used as the Proxy function of the transport
+func (c *Crawler) proxyForRequest(req *http.Request) (*url.URL, error) {
+	if c.proxies == nil {
+		return nil, nil
+	}
+	proxy, ok := req.Context().Value(proxyContextKey{}).(*proxyEntry)
+	if !ok {
+		proxy = c.proxies.pick(req.URL.Host)
+	}
+	return proxy.url, nil
+}
@@ -0,0 +1,328 @@
+package crawdad
+
+import (
+	"bytes"
+	"encoding/json"
+	"net/http"
+	"regexp"
+	"strings"
+
+	"github.com/andybalholm/cascadia"
+	"github.com/antchfx/htmlquery"
+	"github.com/pkg/errors"
+	"golang.org/x/net/html"
+)
+
+// ContentConfig turns on extracting the main text of pages, without the
+// navigation, ads and other boilerplate around it
+type ContentConfig struct {
+	Enabled bool
+	// Selector is a CSS selector for the main content, for sites where it
+	// is known. The content is found by scoring the page if it is empty or
+	// does not match.
+	Selector string
+	// Remove are CSS selectors of boilerplate to drop in addition to the
+	// elements that are always dropped, like <nav> and <aside>
+	Remove []string
+}
+
+// MainContent is the article of a page
+type MainContent struct {
+	Title     string `json:"title,omitempty"`
+	Byline    string `json:"byline,omitempty"`
+	Published string `json:"published,omitempty"`
+	Text      string `json:"text"`
+	WordCount int    `json:"word_count"`
+}
+
+// compiledContent is a ContentConfig with its selectors compiled
+type compiledContent struct {
+	selector cascadia.Selector
+	remove   []cascadia.Selector
+}
+
+func compileContent(config ContentConfig) (compiled *compiledContent, err error) {
+	if !config.Enabled {
+		return
+	}
+	compiled = new(compiledContent)
+	if config.Selector != "" {
+		compiled.selector, err = cascadia.Compile(config.Selector)
+		if err != nil {
+			return nil, errors.Wrap(err, "bad content selector")
+		}
+	}
+	for _, remove := range config.Remove {
+		var sel cascadia.Selector
+		sel, err = cascadia.Compile(remove)
+		if err != nil {
+			return nil, errors.Wrap(err, "bad selector to remove from content")
+		}
+		compiled.remove = append(compiled.remove, sel)
+	}
+	return
+}
+
+var (
+	boilerplateTags = map[string]bool{
+		"script": true, "style": true, "noscript": true, "nav": true, "header": true,
+		"footer": true, "aside": true, "form": true, "iframe": true, "svg": true,
+		"button": true, "select": true, "template": true, "object": true, "embed": true,
+	}
+	blockTags = map[string]bool{
+		"p": true, "div": true, "section": true, "article": true, "main": true,
+		"h1": true, "h2": true, "h3": true, "h4": true, "h5": true, "h6": true,
+		"ul": true, "ol": true, "li": true, "dl": true, "dt": true, "dd": true,
+		"blockquote": true, "pre": true, "table": true, "tr": true, "figure": true,
+		"figcaption": true, "hr": true, "br": true,
+	}
+	unlikelyContent = regexp.MustCompile(`(?i)ad-|ads|advert|banner|breadcrumb|combx|comment|community|cookie|disqus|extra|footer|header|menu|modal|nav|pager|popup|promo|related|remark|rss|share|shoutbox|sidebar|skyscraper|social|sponsor|subscribe|tags|widget`)
+	likelyContent   = regexp.MustCompile(`(?i)and|article|body|column|content|entry|hentry|main|page|post|story|text`)
+	positiveContent = regexp.MustCompile(`(?i)article|body|content|entry|hentry|main|page|post|story|text|blog`)
+	negativeContent = regexp.MustCompile(`(?i)comment|com-|contact|foot|footer|footnote|masthead|media|meta|outbrain|promo|related|scroll|share|shoutbox|sidebar|sponsor|shopping|tags|tool|widget`)
+)
+
+func (cc *compiledContent) Name() string {
+	return "main_content"
+}
+
+// Extract finds the MainContent of a page, or nil if the page has no text
+func (cc *compiledContent) Extract(url string, header http.Header, body []byte) (data json.RawMessage, err error) {
+	doc, err := html.Parse(bytes.NewReader(body))
+	if err != nil {
+		err = errors.Wrap(err, "could not parse html")
+		return
+	}
+	content := MainContent{
+		Title:     pageTitle(doc),
+		Byline:    byline(doc),
+		Published: published(doc),
+	}
+
+	// the metadata is read before the boilerplate is dropped, as it is
+	// often in the header
+	removeBoilerplate(doc, cc.remove)
+	var top *html.Node
+	if cc.selector != nil {
+		top = cascadia.Query(doc, cc.selector)
+	}
+	if top == nil {
+		top = topCandidate(doc)
+	}
+	if top == nil {
+		return
+	}
+	content.Text = blockText(top)
+	if content.Text == "" {
+		return
+	}
+	content.WordCount = len(strings.Fields(content.Text))
+	return json.Marshal(content)
+}
+
+// pageTitle prefers the title given for sharing, then the <title> without the
+// site name, then the first heading
+func pageTitle(doc *html.Node) string {
+	if n := htmlquery.FindOne(doc, "//meta[@property='og:title']"); n != nil && nodeAttr(n, "content") != "" {
+		return strings.TrimSpace(nodeAttr(n, "content"))
+	}
+	if n := htmlquery.FindOne(doc, "//title"); n != nil {
+		title := nodeText(n)
+		for _, sep := range []string{" | ", " - ", " – ", " — ", " :: "} {
+			if i := strings.LastIndex(title, sep); i > 0 {
+				title = strings.TrimSpace(title[:i])
+				break
+			}
+		}
+		if title != "" {
+			return title
+		}
+	}
+	if n := htmlquery.FindOne(doc, "//h1"); n != nil {
+		return nodeText(n)
+	}
+	return ""
+}
+
+func byline(doc *html.Node) string {
+	for _, expr := range []string{
+		"//meta[@name='author']/@content",
+		"//meta[@property='article:author']/@content",
+		"//*[@rel='author']",
+		"//*[@itemprop='author']",
+		"//*[contains(concat(' ', normalize-space(@class), ' '), ' byline ')]",
+		"//*[contains(concat(' ', normalize-space(@class), ' '), ' author ')]",
+	} {
+		if n := htmlquery.FindOne(doc, expr); n != nil {
+			if author := nodeText(n); author != "" && len(author) < 100 {
+				return author
+			}
+		}
+	}
+	return ""
+}
+
+func published(doc *html.Node) string {
+	for _, expr := range []string{
+		"//meta[@property='article:published_time']/@content",
+		"//meta[@itemprop='datePublished']/@content",
+		"//*[@itemprop='datePublished']/@datetime",
+		"//meta[@name='date']/@content",
+		"//meta[@name='pubdate']/@content",
+		"//time[@datetime]/@datetime",
+	} {
+		if n := htmlquery.FindOne(doc, expr); n != nil {
+			if date := nodeText(n); date != "" {
+				return date
+			}
+		}
+	}
+	return ""
+}
+
+// removeBoilerplate drops elements that are never part of the content
+func removeBoilerplate(doc *html.Node, remove []cascadia.Selector) {
+	var drop []*html.Node
+	for _, sel := range remove {
+		drop = append(drop, cascadia.QueryAll(doc, sel)...)
+	}
+	var walk func(n *html.Node)
+	walk = func(n *html.Node) {
+		for child := n.FirstChild; child != nil; child = child.NextSibling {
+			if child.Type == html.CommentNode {
+				drop = append(drop, child)
+				continue
+			}
+			if child.Type != html.ElementNode {
+				continue
+			}
+			if child.Data != "body" && child.Data != "article" && child.Data != "main" {
+				match := nodeAttr(child, "class") + " " + nodeAttr(child, "id") + " " + nodeAttr(child, "role")
+				if boilerplateTags[child.Data] || hasAttr(child, "hidden") ||
+					(unlikelyContent.MatchString(match) && !likelyContent.MatchString(match)) {
+					drop = append(drop, child)
+					continue
+				}
+			}
+			walk(child)
+		}
+	}
+	walk(doc)
+	for _, n := range drop {
+		if n.Parent != nil {
+			n.Parent.RemoveChild(n)
+		}
+	}
+}
+
+// topCandidate scores the paragraphs of a page on their parents and returns
+// the element with the most text that is not links
+func topCandidate(doc *html.Node) (top *html.Node) {
+	scores := make(map[*html.Node]float64)
+	initScore := func(n *html.Node) {
+		if _, ok := scores[n]; ok {
+			return
+		}
+		var score float64
+		switch n.Data {
+		case "article", "main":
+			score = 10
+		case "div":
+			score = 5
+		case "pre", "td", "blockquote", "section":
+			score = 3
+		case "ol", "ul", "dl", "dd", "dt", "li", "form":
+			score = -3
+		case "h1", "h2", "h3", "h4", "h5", "h6", "th":
+			score = -5
+		}
+		match := nodeAttr(n, "class") + " " + nodeAttr(n, "id")
+		if positiveContent.MatchString(match) {
+			score += 25
+		}
+		if negativeContent.MatchString(match) {
+			score -= 25
+		}
+		scores[n] = score
+	}
+	for _, p := range htmlquery.Find(doc, "//p | //pre | //td | //blockquote") {
+		text := nodeText(p)
+		if len(text) < 25 || p.Parent == nil {
+			continue
+		}
+		score := 1 + float64(strings.Count(text, ",")) + float64(len(text)/100)
+		if score > 4 {
+			score = 4
+		}
+		parent := p.Parent
+		initScore(parent)
+		scores[parent] += score
+		if grandparent := parent.Parent; grandparent != nil && grandparent.Type == html.ElementNode {
+			initScore(grandparent)
+			scores[grandparent] += score / 2
+		}
+	}
+	// candidates are compared in document order, so the first one wins a tie
+	var best float64
+	var walk func(n *html.Node)
+	walk = func(n *html.Node) {
+		if score, ok := scores[n]; ok {
+			score *= 1 - linkDensity(n)
+			if top == nil || score > best {
+				top, best = n, score
+			}
+		}
+		for child := n.FirstChild; child != nil; child = child.NextSibling {
+			walk(child)
+		}
+	}
+	walk(doc)
+	if top == nil {
+		top = htmlquery.FindOne(doc, "//body")
+	}
+	return
+}
+
+// linkDensity is the share of the text of a node that is in links
+func linkDensity(n *html.Node) float64 {
+	length := len(nodeText(n))
+	if length == 0 {
+		return 0
+	}
+	links := 0
+	for _, a := range htmlquery.Find(n, ".//a") {
+		links += len(nodeText(a))
+	}
+	return float64(links) / float64(length)
+}
+
+// blockText returns the text of a node, with a paragraph for each block
+func blockText(n *html.Node) string {
+	var paragraphs []string
+	var current strings.Builder
+	flush := func() {
+		if p := strings.Join(strings.Fields(current.String()), " "); p != "" {
+			paragraphs = append(paragraphs, p)
+		}
+		current.Reset()
+	}
+	var walk func(n *html.Node)
+	walk = func(n *html.Node) {
+		switch n.Type {
+		case html.TextNode:
+			current.WriteString(n.Data)
+			return
+		case html.ElementNode:
+			if blockTags[n.Data] {
+				flush()
+				defer flush()
+			}
+		}
+		for child := n.FirstChild; child != nil; child = child.NextSibling {
+			walk(child)
+		}
+	}
+	walk(n)
+	flush()
+	return strings.Join(paragraphs, "\n\n")
+}
@@ -0,0 +1,67 @@
+package crawdad
+
+import (
+	"encoding/json"
+	"strings"
+	"testing"
+
+	"github.com/antchfx/htmlquery"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestExtractContent(t *testing.T) {
+	body := []byte(`<html><head>
+<title>Why I made a book service | rpiai</title>
+<meta name="author" content="Zack">
+<meta property="article:published_time" content="2019-05-12T16:03:11Z">
+</head><body>
+<header><a href="/">Home</a> <a href="/about">About</a></header>
+<nav><ul><li><a href="/a">Archive</a></li></ul></nav>
+<div class="sidebar"><p>Subscribe to the newsletter, it is great, really.</p></div>
+<div class="post-content">
+  <h1>Why I made a book service</h1>
+  <p>All other book suggestions lacked, so I made something that actually worked.</p>
+  <p>It uses <b>millions</b> of ratings, collected over a few years, to find similar books.</p>
+  <script>var tracking = true;</script>
+  <div class="share-buttons"><a href="/share">Share</a></div>
+</div>
+<footer><p>Copyright 2019, all rights reserved, and so on and so forth.</p></footer>
+</body></html>`)
+
+	cc, err := compileContent(ContentConfig{Enabled: true})
+	assert.Nil(t, err)
+	result, err := cc.Extract("https://rpiai.com/", nil, body)
+	assert.Nil(t, err)
+	var content MainContent
+	assert.Nil(t, json.Unmarshal(result, &content))
+	assert.Equal(t, "Why I made a book service", content.Title)
+	assert.Equal(t, "Zack", content.Byline)
+	assert.Equal(t, "2019-05-12T16:03:11Z", content.Published)
+	assert.Equal(t, "Why I made a book service\n\n"+
+		"All other book suggestions lacked, so I made something that actually worked.\n\n"+
+		"It uses millions of ratings, collected over a few years, to find similar books.", content.Text)
+	assert.Equal(t, 32, content.WordCount)
+
+	// a known selector wins and more boilerplate can be removed
+	cc, err = compileContent(ContentConfig{Enabled: true, Selector: ".post-content", Remove: []string{"h1"}})
+	assert.Nil(t, err)
+	result, err = cc.Extract("https://rpiai.com/", nil, body)
+	assert.Nil(t, err)
+	assert.Nil(t, json.Unmarshal(result, &content))
+	assert.NotContains(t, content.Text, "Why I made")
+	assert.Contains(t, content.Text, "All other book suggestions")
+
+	cc, err = compileContent(ContentConfig{})
+	assert.Nil(t, err)
+	assert.Nil(t, cc)
+	_, err = compileContent(ContentConfig{Enabled: true, Selector: "p["})
+	assert.NotNil(t, err)
+
+	// ties go to the first candidate on the page
+	doc, err := htmlquery.Parse(strings.NewReader(`<body><section><p>The first section of the page.</p></section>` +
+		`<section><p>The second section of the page.</p></section></body>`))
+	assert.Nil(t, err)
+	for i := 0; i < 20; i++ {
+		assert.Equal(t, "The first section of the page.", nodeText(topCandidate(doc)))
+	}
+}
@@ -0,0 +1,45 @@
+package crawdad
+
+import (
+	"encoding/json"
+	"time"
+)
+
+// Record is the information saved for each URL in the 'done' database,
+// or in the 'skipped' and 'trash' databases along with the Reason it was
+// skipped or failed
+type Record struct {
+	URL             string            `json:"url"`
+	StatusCode      int               `json:"status_code"`
+	FetchedAt       time.Time         `json:"fetched_at"`
+	Proxy           string            `json:"proxy,omitempty"`
+	RequestHeaders  map[string]string `json:"request_headers,omitempty"`
+	Redirects       []string          `json:"redirects,omitempty"`
+	ContentType     string            `json:"content_type,omitempty"`
+	ContentEncoding string            `json:"content_encoding,omitempty"`
+	Charset         string            `json:"charset,omitempty"`
+	TransferSize    int64             `json:"transfer_size"`
+	DecodedSize     int64             `json:"decoded_size"`
+	Truncated       bool              `json:"truncated,omitempty"`
+	ArchivePath     string            `json:"archive_path,omitempty"`
+	ArchiveHead     string            `json:"archive_head,omitempty"`
+	// Extracted has a section for each Extractor that found something
+	Extracted map[string]json.RawMessage `json:"extracted,omitempty"`
+	Links     []Link                     `json:"links,omitempty"`
+	NoIndex   bool                       `json:"noindex,omitempty"`
+	NoFollow  bool                       `json:"nofollow,omitempty"`
+	Reason    string                     `json:"reason,omitempty"`
+	// Canonical is the URL of <link rel="canonical">, if the page has one
+	Canonical string `json:"canonical,omitempty"`
+	// Error is why the request failed, for trashed URLs with the "error" Reason
+	Error string `json:"error,omitempty"`
+	// Checked is true for links to other sites that were only checked, with
+	// CheckLinks, rather than crawled
+	Checked bool `json:"checked,omitempty"`
+	// LastModified is the Last-Modified header of the response
+	LastModified string `json:"last_modified,omitempty"`
+	// FetchMillis is how long the request took until the body was read
+	FetchMillis int64 `json:"fetch_ms,omitempty"`
+	// Analysis is set by Analyze
+	Analysis *Analysis `json:"analysis,omitempty"`
+}
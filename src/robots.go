@@ -0,0 +1,84 @@
+package crawdad
+
+import (
+	"bytes"
+	"net/http"
+	"strings"
+
+	"golang.org/x/net/html"
+)
+
+// robotsName is the name crawdad answers to in robots directives, in
+// addition to "robots"
+const robotsName = "crawdad"
+
+// robotsDirectives are the indexing rules a page gives to crawlers
+type robotsDirectives struct {
+	noIndex  bool
+	noFollow bool
+}
+
+func (r *robotsDirectives) add(directives string) {
+	for _, directive := range strings.Split(strings.ToLower(directives), ",") {
+		switch strings.TrimSpace(directive) {
+		case "noindex":
+			r.noIndex = true
+		case "nofollow":
+			r.noFollow = true
+		case "none":
+			r.noIndex = true
+			r.noFollow = true
+		}
+	}
+}
+
+// parseRobots reads the X-Robots-Tag headers and the robots <meta> tags of a
+// page. Directives for other crawlers (e.g. "googlebot: noindex") are ignored.
+func parseRobots(header http.Header, body []byte) (r robotsDirectives) {
+	for _, value := range header["X-Robots-Tag"] {
+		// a single word before a colon is a crawler name, or a directive
+		// with a value like "max-snippet: 20" that is not used anyway
+		if i := strings.Index(value, ":"); i >= 0 && !strings.ContainsAny(strings.TrimSpace(value[:i]), ", ") {
+			agent := strings.ToLower(strings.TrimSpace(value[:i]))
+			if agent != robotsName && agent != "robots" {
+				continue
+			}
+			value = value[i+1:]
+		}
+		r.add(value)
+	}
+
+	z := html.NewTokenizer(bytes.NewReader(body))
+	for {
+		tt := z.Next()
+		switch tt {
+		case html.ErrorToken:
+			return
+		case html.StartTagToken, html.SelfClosingTagToken:
+			token := z.Token()
+			if token.Data == "body" {
+				return
+			}
+			if token.Data != "meta" {
+				continue
+			}
+			name := strings.ToLower(getAttr(token, "name"))
+			if name == "robots" || name == robotsName {
+				r.add(getAttr(token, "content"))
+			}
+		}
+	}
+}
+
+// robotsSitemaps are the URLs of the Sitemap lines of a robots.txt
+func robotsSitemaps(robotsTxt []byte) (sitemaps []string) {
+	for _, line := range strings.Split(string(robotsTxt), "\n") {
+		parts := strings.SplitN(line, ":", 2)
+		if len(parts) == 2 && strings.EqualFold(strings.TrimSpace(parts[0]), "sitemap") {
+			if sitemap := strings.TrimSpace(parts[1]); sitemap != "" {
+				sitemaps = append(sitemaps, sitemap)
+			}
+		}
+	}
+	return
+}
@@ -0,0 +1,39 @@
+package crawdad
+
+import (
+	"net/http"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestParseRobots(t *testing.T) {
+	r := parseRobots(http.Header{}, []byte(`<html><head><meta name="robots" content="NOINDEX, follow"></head></html>`))
+	assert.True(t, r.noIndex)
+	assert.False(t, r.noFollow)
+
+	r = parseRobots(http.Header{}, []byte(`<html><head><meta name="crawdad" content="none"></head></html>`))
+	assert.True(t, r.noIndex)
+	assert.True(t, r.noFollow)
+
+	// meta tags in the body are not directives
+	r = parseRobots(http.Header{}, []byte(`<html><body><meta name="robots" content="nofollow"></body></html>`))
+	assert.False(t, r.noFollow)
+
+	header := http.Header{}
+	header.Add("X-Robots-Tag", "googlebot: nofollow")
+	header.Add("X-Robots-Tag", "noindex, max-snippet: 20")
+	r = parseRobots(header, nil)
+	assert.True(t, r.noIndex)
+	assert.False(t, r.noFollow)
+
+	header = http.Header{}
+	header.Add("X-Robots-Tag", "crawdad: nofollow")
+	r = parseRobots(header, nil)
+	assert.True(t, r.noFollow)
+}
+
+func TestRobotsSitemaps(t *testing.T) {
+	robotsTxt := []byte("User-agent: *\r\nDisallow: /private\r\nSitemap: https://example.com/sitemap.xml\r\nSITEMAP:https://example.com/news.xml\r\nSitemap:\r\n")
+	assert.Equal(t, []string{"https://example.com/sitemap.xml", "https://example.com/news.xml"}, robotsSitemaps(robotsTxt))
+}
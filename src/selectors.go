@@ -0,0 +1,215 @@
+package crawdad
+
+import (
+	"bytes"
+	"encoding/json"
+	"net/http"
+	"regexp"
+	"strconv"
+	"strings"
+
+	"github.com/BurntSushi/toml"
+	"github.com/andybalholm/cascadia"
+	"github.com/antchfx/htmlquery"
+	"github.com/antchfx/xpath"
+	"github.com/pkg/errors"
+	"golang.org/x/net/html"
+)
+
+// Field is a named value to extract from each page, selected with either a
+// CSS selector or an XPath expression
+type Field struct {
+	Name  string `toml:"name"`
+	CSS   string `toml:"css"`
+	XPath string `toml:"xpath"`
+	// Attribute is the attribute to take from the matched elements, their
+	// text is taken if it is empty
+	Attribute string `toml:"attribute"`
+	// Multiple keeps every match as a list instead of only the first
+	Multiple bool `toml:"multiple"`
+	// Transforms are applied in order to each value:
+	//
+	//	trim           collapse whitespace
+	//	regex:<expr>   keep the first capture group (or the whole match)
+	//	number         parse as a number, ignoring anything but digits
+	Transforms []string `toml:"transforms"`
+}
+
+// ParseFields reads fields from a TOML config like
+//
+//	[[field]]
+//	name = "price"
+//	css = "span.price"
+//	transforms = ["trim", "number"]
+func ParseFields(config string) (fields []Field, err error) {
+	var parsed struct {
+		Field []Field `toml:"field"`
+	}
+	_, err = toml.Decode(config, &parsed)
+	if err != nil {
+		err = errors.Wrap(err, "could not parse fields")
+		return
+	}
+	fields = parsed.Field
+	return
+}
+
+// compiledField is a Field ready to be evaluated
+type compiledField struct {
+	Field
+	css        cascadia.Selector
+	xpath      *xpath.Expr
+	transforms []func(string) (interface{}, bool)
+}
+
+// compileFields checks the fields and compiles their selectors and
+// transforms, so that mistakes show up before crawling
+func compileFields(fields []Field) (compiled []compiledField, err error) {
+	names := make(map[string]bool)
+	for _, f := range fields {
+		if f.Name == "" {
+			return nil, errors.New("field needs a name")
+		}
+		if names[f.Name] {
+			return nil, errors.New("field '" + f.Name + "' is defined twice")
+		}
+		names[f.Name] = true
+		cf := compiledField{Field: f}
+		switch {
+		case f.CSS != "" && f.XPath != "":
+			return nil, errors.New("field '" + f.Name + "' needs either css or xpath, not both")
+		case f.CSS != "":
+			cf.css, err = cascadia.Compile(f.CSS)
+		case f.XPath != "":
+			cf.xpath, err = xpath.Compile(f.XPath)
+		default:
+			return nil, errors.New("field '" + f.Name + "' needs css or xpath")
+		}
+		if err != nil {
+			return nil, errors.Wrap(err, "bad selector for field '"+f.Name+"'")
+		}
+		for _, transform := range f.Transforms {
+			var fn func(string) (interface{}, bool)
+			fn, err = compileTransform(transform)
+			if err != nil {
+				return nil, errors.Wrap(err, "bad transform for field '"+f.Name+"'")
+			}
+			cf.transforms = append(cf.transforms, fn)
+		}
+		compiled = append(compiled, cf)
+	}
+	return
+}
+
+var notNumber = regexp.MustCompile(`[^0-9.eE+-]`)
+
+// compileTransform returns a function that changes a value, or reports that
+// the value should be dropped
+func compileTransform(transform string) (fn func(string) (interface{}, bool), err error) {
+	switch {
+	case transform == "trim":
+		fn = func(s string) (interface{}, bool) {
+			return strings.Join(strings.Fields(s), " "), true
+		}
+	case transform == "number":
+		fn = func(s string) (interface{}, bool) {
+			n, err := strconv.ParseFloat(notNumber.ReplaceAllString(s, ""), 64)
+			return n, err == nil
+		}
+	case strings.HasPrefix(transform, "regex:"):
+		var re *regexp.Regexp
+		re, err = regexp.Compile(strings.TrimPrefix(transform, "regex:"))
+		if err != nil {
+			return
+		}
+		fn = func(s string) (interface{}, bool) {
+			match := re.FindStringSubmatch(s)
+			if match == nil {
+				return nil, false
+			}
+			if len(match) > 1 {
+				return match[1], true
+			}
+			return match[0], true
+		}
+	default:
+		err = errors.New("unknown transform '" + transform + "', use trim, regex:<expr> or number")
+	}
+	return
+}
+
+// fieldsExtractor saves the values of the fields
+type fieldsExtractor []compiledField
+
+func (fields fieldsExtractor) Name() string {
+	return "fields"
+}
+
+// Extract evaluates the fields on a page and returns their values, or nil if
+// nothing matched
+func (fields fieldsExtractor) Extract(url string, header http.Header, body []byte) (data json.RawMessage, err error) {
+	doc, err := html.Parse(bytes.NewReader(body))
+	if err != nil {
+		err = errors.Wrap(err, "could not parse html")
+		return
+	}
+	values := make(map[string]interface{})
+	for _, f := range fields {
+		var nodes []*html.Node
+		if f.css != nil {
+			nodes = cascadia.QueryAll(doc, f.css)
+		} else {
+			nodes = htmlquery.QuerySelectorAll(doc, f.xpath)
+		}
+		var matches []interface{}
+		for _, node := range nodes {
+			value, ok := f.value(node)
+			if !ok {
+				continue
+			}
+			matches = append(matches, value)
+			if !f.Multiple {
+				break
+			}
+		}
+		if len(matches) == 0 {
+			continue
+		}
+		if f.Multiple {
+			values[f.Name] = matches
+		} else {
+			values[f.Name] = matches[0]
+		}
+	}
+	if len(values) == 0 {
+		return
+	}
+	return json.Marshal(values)
+}
+
+// value gets the text or attribute of a node and applies the transforms
+func (f compiledField) value(node *html.Node) (value interface{}, ok bool) {
+	var s string
+	if f.Attribute != "" {
+		if !hasAttr(node, f.Attribute) {
+			return nil, false
+		}
+		s = nodeAttr(node, f.Attribute)
+	} else {
+		s = htmlquery.InnerText(node)
+	}
+	value = s
+	for _, transform := range f.transforms {
+		// transforms after number work on its text again
+		if str, isString := value.(string); isString {
+			s = str
+		} else {
+			s = strconv.FormatFloat(value.(float64), 'f', -1, 64)
+		}
+		value, ok = transform(s)
+		if !ok {
+			return nil, false
+		}
+	}
+	return value, true
+}
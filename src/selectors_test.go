@@ -0,0 +1,63 @@
+package crawdad
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestSelectFields(t *testing.T) {
+	fields, err := ParseFields(`
+[[field]]
+name = "title"
+css = "h1"
+transforms = ["trim"]
+
+[[field]]
+name = "price"
+xpath = "//span[@class='price']"
+transforms = ["number"]
+
+[[field]]
+name = "tags"
+css = "a[rel=tag]"
+attribute = "href"
+multiple = true
+transforms = ["regex:/tag/(\\w+)"]
+
+[[field]]
+name = "missing"
+css = "div.nothing"
+`)
+	assert.Nil(t, err)
+	assert.Equal(t, 4, len(fields))
+	compiled, err := compileFields(fields)
+	assert.Nil(t, err)
+
+	body := []byte(`<html><body>
+<h1>
+  A  fine
+  hat </h1>
+<span class="price">$1,024.50</span>
+<a rel="tag" href="/tag/hats">hats</a>
+<a rel="tag" href="/tag/wool">wool</a>
+<a rel="tag" href="/about">about</a>
+</body></html>`)
+	result, err := fieldsExtractor(compiled).Extract("https://example.com/", nil, body)
+	assert.Nil(t, err)
+	assert.Equal(t, `{"price":1024.5,"tags":["hats","wool"],"title":"A fine hat"}`, string(result))
+
+	// nothing matched
+	result, err = fieldsExtractor(compiled).Extract("https://example.com/", nil, []byte("<html></html>"))
+	assert.Nil(t, err)
+	assert.Nil(t, result)
+
+	_, err = compileFields([]Field{{Name: "x"}})
+	assert.NotNil(t, err)
+	_, err = compileFields([]Field{{Name: "x", CSS: "a[", XPath: ""}})
+	assert.NotNil(t, err)
+	_, err = compileFields([]Field{{Name: "x", CSS: "a", Transforms: []string{"upper"}}})
+	assert.NotNil(t, err)
+	_, err = compileFields([]Field{{Name: "x", CSS: "a"}, {Name: "x", XPath: "//a"}})
+	assert.NotNil(t, err)
+}
@@ -0,0 +1,307 @@
+package crawdad
+
+import (
+	"bufio"
+	"bytes"
+	"compress/gzip"
+	"encoding/xml"
+	"fmt"
+	"net/http"
+	"net/url"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/goware/urlx"
+	"github.com/pkg/errors"
+	log "github.com/schollz/logger"
+)
+
+// the limits of a single sitemap file, from sitemaps.org
+var (
+	sitemapMaxURLs      = 50000
+	sitemapMaxSize      = 50 * 1024 * 1024
+	sitemapMaxURLLength = 2048
+)
+
+const sitemapIndexFilename = "sitemap.xml"
+
+// maxSitemaps is how many sitemaps are read for seeds, counting the ones in
+// sitemap indexes
+const maxSitemaps = 1000
+
+const sitemapHeader = `<?xml version="1.0" encoding="UTF-8"?>
+<urlset xmlns="http://www.sitemaps.org/schemas/sitemap/0.9">
+`
+const sitemapFooter = "</urlset>\n"
+
+type sitemapEntry struct {
+	loc     string
+	lastmod time.Time
+}
+
+// WriteSitemaps writes gzipped sitemaps of the done URLs to dir, split so that
+// none has more than 50,000 URLs or 50MB, and a sitemap.xml index of them.
+// Only pages that were fetched with a 200, are not noindex and are their own
+// canonical URL are included, with their Last-Modified header as lastmod. The
+// index points at the sitemaps as they will be published under sitemapURL.
+func (c *Crawler) WriteSitemaps(dir, sitemapURL string) (files []string, err error) {
+	entries, err := c.sitemapEntries()
+	if err != nil {
+		return
+	}
+	err = os.MkdirAll(dir, 0755)
+	if err != nil {
+		err = errors.Wrap(err, "could not make sitemap directory")
+		return
+	}
+
+	var f *os.File
+	var gz *gzip.Writer
+	var numURLs, size int
+	var lastmod time.Time
+	var lastmods []time.Time
+	finish := func() (err error) {
+		if gz == nil {
+			return
+		}
+		gz.Write([]byte(sitemapFooter))
+		err = gz.Close()
+		if errClose := f.Close(); err == nil {
+			err = errClose
+		}
+		lastmods = append(lastmods, lastmod)
+		gz = nil
+		return
+	}
+	for _, entry := range entries {
+		url := "  <url>\n    <loc>" + xmlEscape(entry.loc) + "</loc>\n"
+		if !entry.lastmod.IsZero() {
+			url += "    <lastmod>" + entry.lastmod.UTC().Format(time.RFC3339) + "</lastmod>\n"
+		}
+		url += "  </url>\n"
+		if gz != nil && (numURLs >= sitemapMaxURLs || size+len(url)+len(sitemapFooter) > sitemapMaxSize) {
+			err = finish()
+			if err != nil {
+				return
+			}
+		}
+		if gz == nil {
+			name := fmt.Sprintf("sitemap-%05d.xml.gz", len(files)+1)
+			f, err = os.Create(filepath.Join(dir, name))
+			if err != nil {
+				err = errors.Wrap(err, "could not create sitemap")
+				return
+			}
+			files = append(files, name)
+			gz = gzip.NewWriter(f)
+			gz.Write([]byte(sitemapHeader))
+			numURLs, size, lastmod = 0, len(sitemapHeader), time.Time{}
+		}
+		_, err = gz.Write([]byte(url))
+		if err != nil {
+			f.Close()
+			return
+		}
+		numURLs++
+		size += len(url)
+		if entry.lastmod.After(lastmod) {
+			lastmod = entry.lastmod
+		}
+	}
+	err = finish()
+	if err != nil {
+		return
+	}
+
+	// the index lists every sitemap with the newest lastmod in it
+	index, err := os.Create(filepath.Join(dir, sitemapIndexFilename))
+	if err != nil {
+		err = errors.Wrap(err, "could not create sitemap index")
+		return
+	}
+	w := bufio.NewWriter(index)
+	w.WriteString(`<?xml version="1.0" encoding="UTF-8"?>
+<sitemapindex xmlns="http://www.sitemaps.org/schemas/sitemap/0.9">
+`)
+	if !strings.HasSuffix(sitemapURL, "/") {
+		sitemapURL += "/"
+	}
+	for i, name := range files {
+		fmt.Fprintf(w, "  <sitemap>\n    <loc>%s</loc>\n", xmlEscape(sitemapURL+name))
+		if !lastmods[i].IsZero() {
+			fmt.Fprintf(w, "    <lastmod>%s</lastmod>\n", lastmods[i].UTC().Format(time.RFC3339))
+		}
+		w.WriteString("  </sitemap>\n")
+	}
+	w.WriteString("</sitemapindex>\n")
+	err = w.Flush()
+	if errClose := index.Close(); err == nil {
+		err = errClose
+	}
+	files = append(files, sitemapIndexFilename)
+	log.Infof("wrote %d URLs to %d sitemaps in %s", len(entries), len(files)-1, dir)
+	return
+}
+
+// sitemapEntries are the done pages that belong in a sitemap, by URL
+func (c *Crawler) sitemapEntries() (entries []sitemapEntry, err error) {
+	seen := make(map[string]int)
+	err = c.exportRecords(ExportFilter{}, func(r ExportRecord) error {
+		if r.StatusCode != 200 || r.NoIndex {
+			return nil
+		}
+		// pages are listed where any redirects ended up
+		loc := finalURL(r.Record)
+		if r.Canonical != "" && normalizeURL(r.Canonical) != normalizeURL(loc) {
+			return nil
+		}
+		if !c.inScope(loc) || len(loc) > sitemapMaxURLLength {
+			return nil
+		}
+		// the time of the fetch says nothing about when the page changed, so
+		// pages the server gave no Last-Modified for have no lastmod
+		lastmod, _ := http.ParseTime(r.LastModified)
+		// a page reached from several URLs keeps the newest
+		if i, ok := seen[loc]; ok {
+			if lastmod.After(entries[i].lastmod) {
+				entries[i].lastmod = lastmod
+			}
+			return nil
+		}
+		seen[loc] = len(entries)
+		entries = append(entries, sitemapEntry{loc: loc, lastmod: lastmod})
+		return nil
+	})
+	sort.Slice(entries, func(i, j int) bool {
+		return entries[i].loc < entries[j].loc
+	})
+	return
+}
+
+func normalizeURL(link string) string {
+	parsed, err := urlx.Parse(link)
+	if err != nil {
+		return link
+	}
+	normalized, err := urlx.Normalize(parsed)
+	if err != nil {
+		return link
+	}
+	return normalized
+}
+
+// SeedSitemaps adds the URLs of the sitemaps listed in the robots.txt of the
+// base URL as seeds, following sitemap indexes. Sitemaps that can not be read
+// are skipped, and ones on other hosts are fetched without the headers and
+// cookie of the crawl.
+func (c *Crawler) SeedSitemaps() (err error) {
+	base, err := url.Parse(c.Settings.BaseURL)
+	if err != nil {
+		return
+	}
+	robotsTxt, err := c.get(base.Scheme+"://"+base.Host+"/robots.txt", int64(sitemapMaxSize))
+	if err != nil {
+		err = errors.Wrap(err, "could not get robots.txt")
+		return
+	}
+	queue := robotsSitemaps(robotsTxt)
+	seen := make(map[string]bool)
+	var seeds []string
+	for len(queue) > 0 && len(seen) < maxSitemaps {
+		sitemapURL := queue[0]
+		queue = queue[1:]
+		if seen[sitemapURL] {
+			continue
+		}
+		seen[sitemapURL] = true
+		body, errGet := c.get(sitemapURL, int64(sitemapMaxSize))
+		if errGet != nil {
+			log.Warn(errors.Wrap(errGet, "could not get sitemap"))
+			continue
+		}
+		urls, sitemaps, errParse := parseSitemap(body, int64(sitemapMaxSize))
+		if errParse != nil {
+			log.Warn(errors.Wrap(errParse, "could not read sitemap "+sitemapURL))
+			continue
+		}
+		queue = append(queue, sitemaps...)
+		for _, u := range urls {
+			if c.inScope(u) {
+				seeds = append(seeds, u)
+			}
+		}
+	}
+	log.Infof("found %d URLs in %d sitemaps", len(seeds), len(seen))
+	_, err = c.AddSeeds(seeds)
+	return
+}
+
+// parseSitemap returns the URLs of a sitemap, or the sitemaps of a sitemap
+// index. Gzipped sitemaps are decompressed first, up to maxSize bytes.
+func parseSitemap(body []byte, maxSize int64) (urls, sitemaps []string, err error) {
+	if bytes.HasPrefix(body, []byte{0x1f, 0x8b}) {
+		var truncated bool
+		body, truncated, err = decodeBody(body, "gzip", maxSize)
+		if err != nil {
+			return
+		}
+		if truncated {
+			err = errors.New(fmt.Sprintf("sitemap is bigger than %d bytes", maxSize))
+			return
+		}
+	}
+	var doc struct {
+		URLs []struct {
+			Loc string `xml:"loc"`
+		} `xml:"url"`
+		Sitemaps []struct {
+			Loc string `xml:"loc"`
+		} `xml:"sitemap"`
+	}
+	err = xml.Unmarshal(body, &doc)
+	if err != nil {
+		return
+	}
+	for _, u := range doc.URLs {
+		if loc := strings.TrimSpace(u.Loc); loc != "" {
+			urls = append(urls, loc)
+		}
+	}
+	for _, sitemap := range doc.Sitemaps {
+		if loc := strings.TrimSpace(sitemap.Loc); loc != "" {
+			sitemaps = append(sitemaps, loc)
+		}
+	}
+	return
+}
+
+// get downloads a URL that is not part of the crawl, which fails if it is
+// bigger than maxSize bytes
+func (c *Crawler) get(link string, maxSize int64) (body []byte, err error) {
+	req, err := http.NewRequest("GET", link, nil)
+	if err != nil {
+		return
+	}
+	c.setHeaders(req)
+	req, _ = c.withProxy(req)
+	resp, err := c.client.Do(req)
+	if err != nil {
+		return
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != 200 {
+		err = errors.New(fmt.Sprintf("got code %d for %s", resp.StatusCode, link))
+		return
+	}
+	body, truncated, err := readBody(resp.Body, maxSize)
+	if err == nil && !truncated {
+		body, truncated, err = decodeBody(body, resp.Header.Get("Content-Encoding"), maxSize)
+	}
+	if err == nil && truncated {
+		err = errors.New(fmt.Sprintf("%s is bigger than %d bytes", link, maxSize))
+	}
+	return
+}
@@ -0,0 +1,149 @@
+package crawdad
+
+import (
+	"bytes"
+	"compress/gzip"
+	"encoding/json"
+	"encoding/xml"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"sort"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestWriteSitemaps(t *testing.T) {
+	dir, err := ioutil.TempDir("", "crawdad")
+	assert.Nil(t, err)
+	defer os.RemoveAll(dir)
+	crawl, err := New()
+	assert.Nil(t, err)
+	crawl.RedisPort = "6377"
+	err = crawl.Init(Settings{BaseURL: "https://example.com"})
+	assert.Nil(t, err)
+	assert.Nil(t, crawl.Flush())
+
+	modified := "Sun, 12 May 2019 16:03:11 GMT"
+	for _, r := range []Record{
+		{URL: "https://example.com/", StatusCode: 200, LastModified: modified},
+		{URL: "https://example.com/a", StatusCode: 200, LastModified: "Sun, 12 May 2019 17:03:11 GMT", Canonical: "https://example.com/a"},
+		{URL: "https://example.com/b", StatusCode: 200, LastModified: modified},
+		// the time it was fetched is not used
+		{URL: "https://example.com/c", StatusCode: 200, FetchedAt: time.Now()},
+		// these are left out
+		{URL: "https://example.com/a?ref=1", StatusCode: 200, Canonical: "https://example.com/a"},
+		{URL: "https://example.com/private", StatusCode: 200, NoIndex: true},
+		{URL: "https://example.com/moved", StatusCode: 200, Redirects: []string{"https://example.com/b"}},
+		{URL: "https://example.com/away", StatusCode: 200, Redirects: []string{"https://other.example.com/"}},
+		{URL: "https://example.com/gone", StatusCode: 404},
+	} {
+		b, _ := json.Marshal(r)
+		assert.Nil(t, crawl.done.Set(r.URL, string(b), 0).Err())
+	}
+
+	defer func(max int) { sitemapMaxURLs = max }(sitemapMaxURLs)
+	sitemapMaxURLs = 3
+	files, err := crawl.WriteSitemaps(dir, "https://example.com/sitemaps")
+	assert.Nil(t, err)
+	assert.Equal(t, []string{"sitemap-00001.xml.gz", "sitemap-00002.xml.gz", "sitemap.xml"}, files)
+
+	type sitemap struct {
+		Loc     string `xml:"loc"`
+		Lastmod string `xml:"lastmod"`
+	}
+	readSitemap := func(name string) (urls []sitemap) {
+		f, err := os.Open(filepath.Join(dir, name))
+		assert.Nil(t, err)
+		defer f.Close()
+		gz, err := gzip.NewReader(f)
+		assert.Nil(t, err)
+		var urlset struct {
+			URLs []sitemap `xml:"url"`
+		}
+		assert.Nil(t, xml.NewDecoder(gz).Decode(&urlset))
+		return urlset.URLs
+	}
+	assert.Equal(t, []sitemap{
+		{"https://example.com/", "2019-05-12T16:03:11Z"},
+		{"https://example.com/a", "2019-05-12T17:03:11Z"},
+		{"https://example.com/b", "2019-05-12T16:03:11Z"},
+	}, readSitemap(files[0]))
+	assert.Equal(t, []sitemap{{"https://example.com/c", ""}}, readSitemap(files[1]))
+
+	b, err := ioutil.ReadFile(filepath.Join(dir, "sitemap.xml"))
+	assert.Nil(t, err)
+	var index struct {
+		Sitemaps []sitemap `xml:"sitemap"`
+	}
+	assert.Nil(t, xml.Unmarshal(b, &index))
+	assert.Equal(t, []sitemap{
+		{"https://example.com/sitemaps/sitemap-00001.xml.gz", "2019-05-12T17:03:11Z"},
+		{"https://example.com/sitemaps/sitemap-00002.xml.gz", ""},
+	}, index.Sitemaps)
+	assert.Nil(t, crawl.Flush())
+}
+
+func TestSeedSitemaps(t *testing.T) {
+	// robots.txt can list sitemaps on other hosts, which get no credentials
+	var leaked []string
+	other := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		for _, name := range []string{"Cookie", "X-Api-Key"} {
+			if r.Header.Get(name) != "" {
+				leaked = append(leaked, name)
+			}
+		}
+		fmt.Fprint(w, `<urlset></urlset>`)
+	}))
+	defer other.Close()
+	var ts *httptest.Server
+	ts = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		assert.Equal(t, "session=abc", r.Header.Get("Cookie"))
+		assert.Equal(t, "abc", r.Header.Get("X-Api-Key"))
+		switch r.URL.Path {
+		case "/robots.txt":
+			fmt.Fprintf(w, "User-agent: *\nDisallow:\nsitemap: %s/sitemap.xml\nsitemap: %s/sitemap.xml\n", ts.URL, other.URL)
+		case "/sitemap.xml":
+			fmt.Fprintf(w, `<sitemapindex><sitemap><loc>%[1]s/pages.xml.gz</loc></sitemap><sitemap><loc>%[1]s/missing.xml</loc></sitemap></sitemapindex>`, ts.URL)
+		case "/pages.xml.gz":
+			gz := gzip.NewWriter(w)
+			fmt.Fprintf(gz, `<urlset><url><loc>%[1]s/a</loc></url><url><loc> %[1]s/b </loc></url><url><loc>https://other.example.com/</loc></url></urlset>`, ts.URL)
+			gz.Close()
+		default:
+			http.NotFound(w, r)
+		}
+	}))
+	defer ts.Close()
+
+	crawl, err := New()
+	assert.Nil(t, err)
+	crawl.RedisPort = "6377"
+	crawl.Cookie = "session=abc"
+	err = crawl.Init(Settings{BaseURL: ts.URL + "/", Headers: map[string]string{"X-Api-Key": "abc"}})
+	assert.Nil(t, err)
+	assert.Nil(t, crawl.Flush())
+
+	assert.Nil(t, crawl.SeedSitemaps())
+	assert.Empty(t, leaked)
+	seeds, err := crawl.shared.SMembers(seedsKey).Result()
+	assert.Nil(t, err)
+	sort.Strings(seeds)
+	assert.Equal(t, []string{ts.URL + "/a", ts.URL + "/b"}, seeds)
+	assert.Equal(t, int64(1), crawl.todo.Exists(ts.URL+"/a").Val())
+	assert.Nil(t, crawl.Flush())
+
+	// gzipped sitemaps are only decompressed up to the limit
+	var big bytes.Buffer
+	gz := gzip.NewWriter(&big)
+	gz.Write(bytes.Repeat([]byte(" "), 1<<20))
+	gz.Close()
+	_, _, err = parseSitemap(big.Bytes(), 1024)
+	assert.NotNil(t, err)
+	_, err = crawl.get(ts.URL+"/sitemap.xml", 16)
+	assert.NotNil(t, err)
+}
@@ -0,0 +1,211 @@
+package crawdad
+
+import (
+	"crypto/sha1"
+	"database/sql"
+	"encoding/hex"
+	"encoding/json"
+	"time"
+
+	"github.com/pkg/errors"
+	log "github.com/schollz/logger"
+	// registers the "sqlite" driver, which is pure Go so no cgo is needed
+	_ "modernc.org/sqlite"
+)
+
+const sqliteSchema = `
+CREATE TABLE IF NOT EXISTS pages (
+	url TEXT PRIMARY KEY,
+	status_code INTEGER,
+	fetched_at TEXT,
+	fetch_ms INTEGER,
+	content_type TEXT,
+	charset TEXT,
+	transfer_size INTEGER,
+	decoded_size INTEGER,
+	noindex INTEGER,
+	nofollow INTEGER,
+	redirects TEXT,
+	extracted TEXT,
+	record_hash TEXT
+);
+CREATE TABLE IF NOT EXISTS links (
+	from_url TEXT,
+	to_url TEXT,
+	source TEXT,
+	rel TEXT,
+	anchor_text TEXT,
+	in_scope INTEGER,
+	PRIMARY KEY (from_url, to_url, source)
+);
+CREATE INDEX IF NOT EXISTS links_to_url ON links (to_url);
+CREATE TABLE IF NOT EXISTS failures (
+	url TEXT PRIMARY KEY,
+	state TEXT,
+	status_code INTEGER,
+	fetched_at TEXT,
+	content_type TEXT,
+	reason TEXT,
+	record_hash TEXT
+);
+`
+
+// sqliteBatch is how many records are written in one transaction
+const sqliteBatch = 1000
+
+// ExportSQLite writes the done URLs and their links, and the trashed and
+// skipped URLs as failures, to a SQLite database. Only the records that are
+// new or changed since the last export to the same database are written.
+// The states of the filter are not used.
+func (c *Crawler) ExportSQLite(path string, filter ExportFilter) (err error) {
+	db, err := sql.Open("sqlite", path)
+	if err != nil {
+		err = errors.Wrap(err, "could not open "+path)
+		return
+	}
+	defer db.Close()
+	_, err = db.Exec(sqliteSchema)
+	if err != nil {
+		err = errors.Wrap(err, "could not create tables")
+		return
+	}
+	// hashes of the records that were exported before
+	hashes := make(map[string]string)
+	for _, table := range []string{"pages", "failures"} {
+		var rows *sql.Rows
+		rows, err = db.Query("SELECT url, record_hash FROM " + table)
+		if err != nil {
+			return
+		}
+		for rows.Next() {
+			var url, hash string
+			if err = rows.Scan(&url, &hash); err != nil {
+				rows.Close()
+				return
+			}
+			hashes[table+" "+url] = hash
+		}
+		rows.Close()
+		if err = rows.Err(); err != nil {
+			return
+		}
+	}
+
+	var tx *sql.Tx
+	pending := 0
+	var numWritten, numUnchanged int
+	filter.States = []string{"done", "trash", "skipped"}
+	err = c.exportRecords(filter, func(r ExportRecord) (err error) {
+		b, _ := json.Marshal(r)
+		sum := sha1.Sum(b)
+		hash := hex.EncodeToString(sum[:])
+		table := "failures"
+		if r.State == "done" {
+			table = "pages"
+		}
+		if hashes[table+" "+r.URL] == hash {
+			numUnchanged++
+			return
+		}
+		if tx == nil {
+			tx, err = db.Begin()
+			if err != nil {
+				return
+			}
+		}
+		if table == "pages" {
+			err = upsertPage(tx, r.Record, hash)
+		} else {
+			err = upsertFailure(tx, r, hash)
+		}
+		if err != nil {
+			err = errors.Wrap(err, "could not write "+r.URL)
+			return
+		}
+		numWritten++
+		pending++
+		if pending >= sqliteBatch {
+			err = tx.Commit()
+			tx = nil
+			pending = 0
+		}
+		return
+	})
+	if tx != nil {
+		if err == nil {
+			err = tx.Commit()
+		} else {
+			tx.Rollback()
+		}
+	}
+	log.Infof("wrote %d new or changed URLs to %s, %d were unchanged", numWritten, path, numUnchanged)
+	return
+}
+
+func sqliteTime(t time.Time) interface{} {
+	if t.IsZero() {
+		return nil
+	}
+	return t.UTC().Format(time.RFC3339Nano)
+}
+
+func upsertPage(tx *sql.Tx, r Record, hash string) (err error) {
+	var redirects, extracted interface{}
+	if len(r.Redirects) > 0 {
+		b, _ := json.Marshal(r.Redirects)
+		redirects = string(b)
+	}
+	if len(r.Extracted) > 0 {
+		b, _ := json.Marshal(r.Extracted)
+		extracted = string(b)
+	}
+	_, err = tx.Exec(`INSERT INTO pages (url, status_code, fetched_at, fetch_ms, content_type, charset, transfer_size, decoded_size, noindex, nofollow, redirects, extracted, record_hash)
+		VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?)
+		ON CONFLICT (url) DO UPDATE SET status_code = excluded.status_code, fetched_at = excluded.fetched_at,
+			fetch_ms = excluded.fetch_ms, content_type = excluded.content_type, charset = excluded.charset, transfer_size = excluded.transfer_size,
+			decoded_size = excluded.decoded_size, noindex = excluded.noindex, nofollow = excluded.nofollow,
+			redirects = excluded.redirects, extracted = excluded.extracted, record_hash = excluded.record_hash`,
+		r.URL, r.StatusCode, sqliteTime(r.FetchedAt), r.FetchMillis, r.ContentType, r.Charset, r.TransferSize, r.DecodedSize,
+		r.NoIndex, r.NoFollow, redirects, extracted, hash)
+	if err != nil {
+		return
+	}
+	// a URL that failed before and was requeued is not a failure anymore
+	_, err = tx.Exec("DELETE FROM failures WHERE url = ?", r.URL)
+	if err != nil {
+		return
+	}
+
+	// the links of a page are replaced all together
+	_, err = tx.Exec("DELETE FROM links WHERE from_url = ?", r.URL)
+	if err != nil {
+		return
+	}
+	for _, link := range r.Links {
+		_, err = tx.Exec("INSERT OR IGNORE INTO links (from_url, to_url, source, rel, anchor_text, in_scope) VALUES (?, ?, ?, ?, ?, ?)",
+			r.URL, link.URL, link.Source, link.Rel, link.Text, link.InScope)
+		if err != nil {
+			return
+		}
+	}
+	return
+}
+
+func upsertFailure(tx *sql.Tx, r ExportRecord, hash string) (err error) {
+	_, err = tx.Exec(`INSERT INTO failures (url, state, status_code, fetched_at, content_type, reason, record_hash)
+		VALUES (?, ?, ?, ?, ?, ?, ?)
+		ON CONFLICT (url) DO UPDATE SET state = excluded.state, status_code = excluded.status_code,
+			fetched_at = excluded.fetched_at, content_type = excluded.content_type, reason = excluded.reason,
+			record_hash = excluded.record_hash`,
+		r.URL, r.State, r.StatusCode, sqliteTime(r.FetchedAt), r.ContentType, r.Reason, hash)
+	if err != nil {
+		return
+	}
+	// a page that was fetched before and failed later is not a page anymore
+	_, err = tx.Exec("DELETE FROM pages WHERE url = ?", r.URL)
+	if err != nil {
+		return
+	}
+	_, err = tx.Exec("DELETE FROM links WHERE from_url = ?", r.URL)
+	return
+}
@@ -0,0 +1,92 @@
+package crawdad
+
+import (
+	"database/sql"
+	"encoding/json"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestExportSQLite(t *testing.T) {
+	dir, err := ioutil.TempDir("", "crawdad")
+	assert.Nil(t, err)
+	defer os.RemoveAll(dir)
+	crawl, err := New()
+	assert.Nil(t, err)
+	crawl.RedisPort = "6377"
+	err = crawl.Init(Settings{BaseURL: "https://example.com"})
+	assert.Nil(t, err)
+	assert.Nil(t, crawl.Flush())
+
+	setRecord := func(r Record) {
+		b, _ := json.Marshal(r)
+		assert.Nil(t, crawl.done.Set(r.URL, string(b), 0).Err())
+	}
+	setRecord(Record{
+		URL:         "https://example.com/",
+		StatusCode:  200,
+		FetchedAt:   time.Date(2019, 5, 12, 16, 3, 11, 0, time.UTC),
+		FetchMillis: 120,
+		Extracted:   map[string]json.RawMessage{"pluck": json.RawMessage(`{"title":"Hats"}`)},
+		Links: []Link{
+			{URL: "https://example.com/about", Source: "a", Text: "About us"},
+			{URL: "https://example.com/style.css", Source: "src"},
+		},
+	})
+	assert.Nil(t, crawl.trash.Set("https://example.com/gone", "", 0).Err())
+
+	path := filepath.Join(dir, "crawl.db")
+	assert.Nil(t, crawl.ExportSQLite(path, ExportFilter{}))
+	db, err := sql.Open("sqlite", path)
+	assert.Nil(t, err)
+	defer db.Close()
+	count := func(query string) (n int) {
+		assert.Nil(t, db.QueryRow(query).Scan(&n))
+		return
+	}
+	assert.Equal(t, 1, count("SELECT count(*) FROM pages"))
+	assert.Equal(t, 120, count("SELECT fetch_ms FROM pages"))
+	assert.Equal(t, 2, count("SELECT count(*) FROM links"))
+	assert.Equal(t, 1, count("SELECT count(*) FROM failures WHERE state = 'trash'"))
+	var extracted string
+	assert.Nil(t, db.QueryRow("SELECT extracted FROM pages").Scan(&extracted))
+	assert.Equal(t, `{"pluck":{"title":"Hats"}}`, extracted)
+	var anchor string
+	assert.Nil(t, db.QueryRow("SELECT anchor_text FROM links WHERE to_url = 'https://example.com/about'").Scan(&anchor))
+	assert.Equal(t, "About us", anchor)
+
+	// changed records are updated and their links replaced
+	db.Exec("UPDATE pages SET status_code = 0")
+	setRecord(Record{
+		URL:        "https://example.com/",
+		StatusCode: 200,
+		Links:      []Link{{URL: "https://example.com/shop", Source: "a"}},
+	})
+	setRecord(Record{URL: "https://example.com/shop", StatusCode: 200})
+	assert.Nil(t, crawl.ExportSQLite(path, ExportFilter{}))
+	assert.Equal(t, 2, count("SELECT count(*) FROM pages WHERE status_code = 200"))
+	assert.Equal(t, 1, count("SELECT count(*) FROM links"))
+	assert.Equal(t, 1, count("SELECT count(*) FROM failures"))
+
+	// unchanged records are left alone
+	db.Exec("UPDATE pages SET status_code = 0")
+	assert.Nil(t, crawl.ExportSQLite(path, ExportFilter{}))
+	assert.Equal(t, 0, count("SELECT count(*) FROM pages WHERE status_code = 200"))
+
+	// URLs that moved between done and the trash move between the tables
+	assert.Nil(t, crawl.trash.Del("https://example.com/gone").Err())
+	setRecord(Record{URL: "https://example.com/gone", StatusCode: 200})
+	assert.Nil(t, crawl.done.Del("https://example.com/").Err())
+	assert.Nil(t, crawl.trash.Set("https://example.com/", "", 0).Err())
+	assert.Nil(t, crawl.ExportSQLite(path, ExportFilter{}))
+	assert.Equal(t, 0, count("SELECT count(*) FROM failures WHERE url = 'https://example.com/gone'"))
+	assert.Equal(t, 1, count("SELECT count(*) FROM pages WHERE url = 'https://example.com/gone'"))
+	assert.Equal(t, 0, count("SELECT count(*) FROM pages WHERE url = 'https://example.com/'"))
+	assert.Equal(t, 0, count("SELECT count(*) FROM links"))
+	assert.Nil(t, crawl.Flush())
+}
@@ -0,0 +1,294 @@
+package crawdad
+
+import (
+	"bytes"
+	"encoding/json"
+	"net/http"
+	"net/url"
+	"strings"
+
+	"github.com/antchfx/htmlquery"
+	"github.com/pkg/errors"
+	log "github.com/schollz/logger"
+	"golang.org/x/net/html"
+)
+
+// StructuredData is the data a page embeds about itself, gathered from
+// JSON-LD, Microdata, RDFa Lite and OpenGraph and Twitter <meta> tags
+type StructuredData struct {
+	JSONLD    []interface{}          `json:"json_ld,omitempty"`
+	Microdata []*Item                `json:"microdata,omitempty"`
+	RDFa      []*Item                `json:"rdfa,omitempty"`
+	OpenGraph map[string]interface{} `json:"opengraph,omitempty"`
+	Twitter   map[string]interface{} `json:"twitter,omitempty"`
+}
+
+// Item is a Microdata or RDFa item. Every property is a list, and a value
+// is either a string or another Item.
+type Item struct {
+	Type       []string                 `json:"type,omitempty"`
+	ID         string                   `json:"id,omitempty"`
+	Properties map[string][]interface{} `json:"properties"`
+}
+
+// itemSyntax tells how items and their properties are marked up
+type itemSyntax struct {
+	// scope is true for elements that start an item
+	scope func(n *html.Node) bool
+	types func(n *html.Node) []string
+	props func(n *html.Node) []string
+	id    func(n *html.Node) string
+	value func(n *html.Node, base *url.URL) string
+}
+
+var microdata = itemSyntax{
+	scope: func(n *html.Node) bool { return hasAttr(n, "itemscope") },
+	types: func(n *html.Node) []string { return strings.Fields(nodeAttr(n, "itemtype")) },
+	props: func(n *html.Node) []string { return strings.Fields(nodeAttr(n, "itemprop")) },
+	id:    func(n *html.Node) string { return nodeAttr(n, "itemid") },
+	value: func(n *html.Node, base *url.URL) string {
+		switch n.Data {
+		case "meta":
+			return nodeAttr(n, "content")
+		case "audio", "embed", "iframe", "img", "source", "track", "video":
+			return resolve(base, nodeAttr(n, "src"))
+		case "a", "area", "link":
+			return resolve(base, nodeAttr(n, "href"))
+		case "object":
+			return resolve(base, nodeAttr(n, "data"))
+		case "data", "meter":
+			return nodeAttr(n, "value")
+		case "time":
+			if hasAttr(n, "datetime") {
+				return nodeAttr(n, "datetime")
+			}
+		}
+		return nodeText(n)
+	},
+}
+
+var rdfa = itemSyntax{
+	scope: func(n *html.Node) bool { return hasAttr(n, "typeof") },
+	types: func(n *html.Node) (types []string) {
+		vocab := ""
+		for p := n; p != nil; p = p.Parent {
+			if hasAttr(p, "vocab") {
+				vocab = nodeAttr(p, "vocab")
+				break
+			}
+		}
+		for _, t := range strings.Fields(nodeAttr(n, "typeof")) {
+			if vocab != "" && !strings.Contains(t, ":") {
+				t = vocab + t
+			}
+			types = append(types, t)
+		}
+		return
+	},
+	props: func(n *html.Node) (props []string) {
+		for _, p := range strings.Fields(nodeAttr(n, "property")) {
+			// OpenGraph and Twitter tags are gathered on their own
+			if !strings.HasPrefix(p, "og:") && !strings.HasPrefix(p, "twitter:") {
+				props = append(props, p)
+			}
+		}
+		return
+	},
+	id: func(n *html.Node) string { return nodeAttr(n, "resource") },
+	value: func(n *html.Node, base *url.URL) string {
+		switch {
+		case hasAttr(n, "content"):
+			return nodeAttr(n, "content")
+		case hasAttr(n, "resource"):
+			return resolve(base, nodeAttr(n, "resource"))
+		case hasAttr(n, "href"):
+			return resolve(base, nodeAttr(n, "href"))
+		case hasAttr(n, "src"):
+			return resolve(base, nodeAttr(n, "src"))
+		case n.Data == "time" && hasAttr(n, "datetime"):
+			return nodeAttr(n, "datetime")
+		}
+		return nodeText(n)
+	},
+}
+
+// structuredDataExtractor saves the StructuredData of pages
+type structuredDataExtractor struct{}
+
+func (structuredDataExtractor) Name() string {
+	return "structured_data"
+}
+
+// Extract returns the StructuredData of a page, or nil if there is none
+func (structuredDataExtractor) Extract(pageURL string, header http.Header, body []byte) (data json.RawMessage, err error) {
+	doc, err := html.Parse(bytes.NewReader(body))
+	if err != nil {
+		err = errors.Wrap(err, "could not parse html")
+		return
+	}
+	base, _ := url.Parse(pageURL)
+	if n := htmlquery.FindOne(doc, "//base[@href]"); n != nil && base != nil {
+		if b, errBase := base.Parse(nodeAttr(n, "href")); errBase == nil {
+			base = b
+		}
+	}
+
+	var found StructuredData
+	for _, n := range htmlquery.Find(doc, "//script") {
+		if !strings.EqualFold(strings.TrimSpace(nodeAttr(n, "type")), "application/ld+json") {
+			continue
+		}
+		var v interface{}
+		if errJSON := json.Unmarshal([]byte(htmlquery.InnerText(n)), &v); errJSON != nil {
+			log.Debugf("bad JSON-LD on %s: %s", pageURL, errJSON.Error())
+			continue
+		}
+		found.JSONLD = append(found.JSONLD, flattenJSONLD(v)...)
+	}
+	found.Microdata = microdata.items(doc, base)
+	found.RDFa = rdfa.items(doc, base)
+	for _, n := range htmlquery.Find(doc, "//meta") {
+		key := nodeAttr(n, "property")
+		if key == "" {
+			key = nodeAttr(n, "name")
+		}
+		key = strings.ToLower(strings.TrimSpace(key))
+		switch {
+		case strings.HasPrefix(key, "og:"):
+			found.OpenGraph = addMeta(found.OpenGraph, key[3:], nodeAttr(n, "content"))
+		case strings.HasPrefix(key, "twitter:"):
+			found.Twitter = addMeta(found.Twitter, key[8:], nodeAttr(n, "content"))
+		}
+	}
+
+	if len(found.JSONLD) == 0 && len(found.Microdata) == 0 && len(found.RDFa) == 0 &&
+		len(found.OpenGraph) == 0 && len(found.Twitter) == 0 {
+		return
+	}
+	return json.Marshal(found)
+}
+
+// flattenJSONLD turns lists and @graph documents into a list of nodes
+func flattenJSONLD(v interface{}) (nodes []interface{}) {
+	switch t := v.(type) {
+	case []interface{}:
+		for _, e := range t {
+			nodes = append(nodes, flattenJSONLD(e)...)
+		}
+	case map[string]interface{}:
+		graph, ok := t["@graph"].([]interface{})
+		if !ok {
+			return []interface{}{t}
+		}
+		for _, e := range graph {
+			// keep the context that applies to the nodes of the graph
+			if node, isMap := e.(map[string]interface{}); isMap && t["@context"] != nil && node["@context"] == nil {
+				node["@context"] = t["@context"]
+			}
+			nodes = append(nodes, flattenJSONLD(e)...)
+		}
+	}
+	return
+}
+
+// addMeta adds a meta tag value, turning repeated tags into a list
+func addMeta(m map[string]interface{}, key, value string) map[string]interface{} {
+	if m == nil {
+		m = make(map[string]interface{})
+	}
+	switch existing := m[key].(type) {
+	case nil:
+		m[key] = value
+	case string:
+		m[key] = []string{existing, value}
+	case []string:
+		m[key] = append(existing, value)
+	}
+	return m
+}
+
+// items returns the top level items of a document, those that are not the
+// property of another item
+func (s itemSyntax) items(doc *html.Node, base *url.URL) (items []*Item) {
+	var walk func(n *html.Node)
+	walk = func(n *html.Node) {
+		if n.Type == html.ElementNode && s.scope(n) && len(s.props(n)) == 0 {
+			items = append(items, s.item(n, base))
+		}
+		for child := n.FirstChild; child != nil; child = child.NextSibling {
+			walk(child)
+		}
+	}
+	walk(doc)
+	return
+}
+
+// item collects the properties of an item, stopping at nested items
+func (s itemSyntax) item(n *html.Node, base *url.URL) *Item {
+	item := &Item{
+		Type:       s.types(n),
+		ID:         s.id(n),
+		Properties: make(map[string][]interface{}),
+	}
+	var walk func(n *html.Node)
+	walk = func(n *html.Node) {
+		for child := n.FirstChild; child != nil; child = child.NextSibling {
+			if child.Type != html.ElementNode {
+				continue
+			}
+			props := s.props(child)
+			if len(props) > 0 {
+				var value interface{}
+				if s.scope(child) {
+					value = s.item(child, base)
+				} else {
+					value = s.value(child, base)
+				}
+				for _, prop := range props {
+					item.Properties[prop] = append(item.Properties[prop], value)
+				}
+			}
+			if !s.scope(child) {
+				walk(child)
+			}
+		}
+	}
+	walk(n)
+	return item
+}
+
+func hasAttr(n *html.Node, key string) bool {
+	for _, attr := range n.Attr {
+		if attr.Key == key {
+			return true
+		}
+	}
+	return false
+}
+
+func nodeAttr(n *html.Node, key string) string {
+	for _, attr := range n.Attr {
+		if attr.Key == key {
+			return attr.Val
+		}
+	}
+	return ""
+}
+
+// nodeText is the text of a node with its whitespace collapsed
+func nodeText(n *html.Node) string {
+	return strings.Join(strings.Fields(htmlquery.InnerText(n)), " ")
+}
+
+// resolve makes a reference absolute, relative to the page
+func resolve(base *url.URL, ref string) string {
+	ref = strings.TrimSpace(ref)
+	if base == nil || ref == "" {
+		return ref
+	}
+	u, err := base.Parse(ref)
+	if err != nil {
+		return ref
+	}
+	return u.String()
+}
@@ -0,0 +1,67 @@
+package crawdad
+
+import (
+	"encoding/json"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestExtractStructuredData(t *testing.T) {
+	body := []byte(`<html><head>
+<meta property="og:title" content="A fine hat">
+<meta property="og:image" content="https://example.com/1.jpg">
+<meta property="og:image" content="https://example.com/2.jpg">
+<meta name="twitter:card" content="summary">
+<script type="application/ld+json">
+{"@context": "https://schema.org", "@graph": [
+  {"@type": "Product", "name": "Hat"},
+  {"@type": "BreadcrumbList", "itemListElement": []}
+]}
+</script>
+<script type="application/ld+json">{"broken": </script>
+</head><body>
+<div itemscope itemtype="https://schema.org/Product">
+  <span itemprop="name">Fine  hat</span>
+  <a itemprop="url" href="/hat">hat</a>
+  <div itemprop="offers" itemscope itemtype="https://schema.org/Offer">
+    <meta itemprop="price" content="10.00">
+  </div>
+</div>
+<div vocab="https://schema.org/" typeof="Person">
+  <span property="name">Jane</span>
+  <a property="url" href="https://jane.example.com">home</a>
+</div>
+</body></html>`)
+	result, err := structuredDataExtractor{}.Extract("https://example.com/shop/", nil, body)
+	assert.Nil(t, err)
+
+	var data StructuredData
+	assert.Nil(t, json.Unmarshal(result, &data))
+	assert.Equal(t, 2, len(data.JSONLD))
+	assert.Equal(t, "Product", data.JSONLD[0].(map[string]interface{})["@type"])
+	assert.Equal(t, "https://schema.org", data.JSONLD[0].(map[string]interface{})["@context"])
+
+	assert.Equal(t, 1, len(data.Microdata))
+	product := data.Microdata[0]
+	assert.Equal(t, []string{"https://schema.org/Product"}, product.Type)
+	assert.Equal(t, []interface{}{"Fine hat"}, product.Properties["name"])
+	assert.Equal(t, []interface{}{"https://example.com/hat"}, product.Properties["url"])
+	offer := product.Properties["offers"][0].(map[string]interface{})
+	assert.Equal(t, []interface{}{"10.00"}, offer["properties"].(map[string]interface{})["price"])
+	_, leaked := product.Properties["price"]
+	assert.False(t, leaked)
+
+	assert.Equal(t, 1, len(data.RDFa))
+	assert.Equal(t, []string{"https://schema.org/Person"}, data.RDFa[0].Type)
+	assert.Equal(t, []interface{}{"Jane"}, data.RDFa[0].Properties["name"])
+	assert.Equal(t, []interface{}{"https://jane.example.com"}, data.RDFa[0].Properties["url"])
+
+	assert.Equal(t, "A fine hat", data.OpenGraph["title"])
+	assert.Equal(t, []interface{}{"https://example.com/1.jpg", "https://example.com/2.jpg"}, data.OpenGraph["image"])
+	assert.Equal(t, "summary", data.Twitter["card"])
+
+	result, err = structuredDataExtractor{}.Extract("https://example.com/", nil, []byte("<html><p>nothing</p></html>"))
+	assert.Nil(t, err)
+	assert.Nil(t, result)
+}
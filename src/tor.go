@@ -0,0 +1,116 @@
+package crawdad
+
+import (
+	"encoding/hex"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"net/textproto"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/pkg/errors"
+	log "github.com/schollz/logger"
+)
+
+// torRenewInterval is the minimum time between new circuits, Tor will
+// ignore NEWNYM signals that are sent more often than this
+const torRenewInterval = 10 * time.Second
+
+// torController talks to the control port of a Tor client
+type torController struct {
+	address    string
+	password   string
+	cookieFile string
+	lastRenew  time.Time
+	exitIP     string
+	sync.Mutex
+}
+
+// command authenticates to the control port and sends a single command
+func (t *torController) command(cmd string) (err error) {
+	netConn, err := net.DialTimeout("tcp", t.address, 10*time.Second)
+	if err != nil {
+		err = errors.Wrap(err, "could not connect to Tor control port")
+		return
+	}
+	defer netConn.Close()
+	netConn.SetDeadline(time.Now().Add(30 * time.Second))
+	conn := textproto.NewConn(netConn)
+
+	auth := "AUTHENTICATE"
+	if t.password != "" {
+		auth += ` "` + strings.NewReplacer(`\`, `\\`, `"`, `\"`).Replace(t.password) + `"`
+	} else if t.cookieFile != "" {
+		var cookie []byte
+		cookie, err = ioutil.ReadFile(t.cookieFile)
+		if err != nil {
+			err = errors.Wrap(err, "could not read Tor cookie")
+			return
+		}
+		auth += " " + hex.EncodeToString(cookie)
+	}
+	for _, line := range []string{auth, cmd} {
+		if _, err = conn.Cmd("%s", line); err != nil {
+			return
+		}
+		if _, _, err = conn.ReadResponse(250); err != nil {
+			err = errors.Wrap(err, "Tor did not accept '"+strings.Fields(line)[0]+"'")
+			return
+		}
+	}
+	conn.Cmd("QUIT")
+	return
+}
+
+// renewTorCircuit asks Tor for a new circuit and checks that the exit IP changed
+func (c *Crawler) renewTorCircuit() {
+	if c.tor == nil {
+		return
+	}
+	// the renewal is claimed under the lock, but Tor and the IP check are
+	// slow, so they run without it
+	c.tor.Lock()
+	if time.Since(c.tor.lastRenew) < torRenewInterval {
+		c.tor.Unlock()
+		return
+	}
+	c.tor.lastRenew = time.Now()
+	c.tor.Unlock()
+
+	log.Info("requesting new Tor circuit")
+	err := c.tor.command("SIGNAL NEWNYM")
+	if err != nil {
+		log.Error(errors.Wrap(err, "could not renew Tor circuit"))
+		return
+	}
+	// existing connections keep using the old circuit
+	if tr, ok := c.client.Transport.(*http.Transport); ok {
+		tr.CloseIdleConnections()
+	}
+
+	ip, err := c.getIP()
+	if err != nil {
+		log.Warn(errors.Wrap(err, "could not check new exit IP"))
+		return
+	}
+	c.tor.Lock()
+	defer c.tor.Unlock()
+	if ip == c.tor.exitIP {
+		log.Warnf("Tor exit IP is still %s", ip)
+	} else {
+		log.Infof("Tor exit IP changed from %s to %s", c.tor.exitIP, ip)
+	}
+	c.tor.exitIP = ip
+}
+
+// exitIP returns the last known Tor exit IP
+func (c *Crawler) exitIP() string {
+	if c.tor == nil {
+		return ""
+	}
+	c.tor.Lock()
+	defer c.tor.Unlock()
+	return c.tor.exitIP
+}
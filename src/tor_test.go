@@ -0,0 +1,53 @@
+package crawdad
+
+import (
+	"bufio"
+	"net"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestTorCommand(t *testing.T) {
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	assert.Nil(t, err)
+	defer ln.Close()
+
+	received := make(chan string, 10)
+	go func() {
+		for {
+			conn, err := ln.Accept()
+			if err != nil {
+				return
+			}
+			r := bufio.NewReader(conn)
+			for {
+				line, err := r.ReadString('\n')
+				if err != nil {
+					break
+				}
+				line = strings.TrimSpace(line)
+				received <- line
+				if strings.Contains(line, "wrong") {
+					conn.Write([]byte("515 Authentication failed: Password did not match\r\n"))
+					break
+				}
+				conn.Write([]byte("250 OK\r\n"))
+				if line == "QUIT" {
+					break
+				}
+			}
+			conn.Close()
+		}
+	}()
+
+	tor := &torController{address: ln.Addr().String(), password: `pa"ss`}
+	assert.Nil(t, tor.command("SIGNAL NEWNYM"))
+	assert.Equal(t, `AUTHENTICATE "pa\"ss"`, <-received)
+	assert.Equal(t, "SIGNAL NEWNYM", <-received)
+	assert.Equal(t, "QUIT", <-received)
+
+	tor.password = "wrong"
+	assert.NotNil(t, tor.command("SIGNAL NEWNYM"))
+}
@@ -0,0 +1,251 @@
+package crawdad
+
+import (
+	"bytes"
+	"compress/gzip"
+	"crypto/rand"
+	"crypto/sha1"
+	"encoding/base32"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/pkg/errors"
+	log "github.com/schollz/logger"
+)
+
+// warcDate is the format of WARC-Date
+const warcDate = "2006-01-02T15:04:05Z"
+
+// warcWriter writes WARC 1.1 records to gzipped files, starting a new file
+// when one gets bigger than maxSize. Each record is its own gzip member, so
+// the records can be read without reading the whole file.
+type warcWriter struct {
+	dir     string
+	prefix  string
+	maxSize int64
+
+	f         *os.File
+	name      string
+	size      int64
+	files     int
+	warcinfo  string
+	startedAt string
+	sync.Mutex
+}
+
+func newWARCWriter(dir, prefix string, maxSize int64) (w *warcWriter, err error) {
+	err = os.MkdirAll(dir, 0755)
+	if err != nil {
+		err = errors.Wrap(err, "could not make WARC directory")
+		return
+	}
+	w = &warcWriter{
+		dir:       dir,
+		prefix:    prefix,
+		maxSize:   maxSize,
+		startedAt: time.Now().UTC().Format("20060102150405"),
+	}
+	return
+}
+
+// warcHeader is a WARC named field, they are written in order
+type warcHeader struct {
+	name, value string
+}
+
+// writeExchange writes a request record and the response record it got,
+// linked by WARC-Concurrent-To
+func (w *warcWriter) writeExchange(targetURI string, date time.Time, request, response []byte, payload []byte, truncated bool) (err error) {
+	w.Lock()
+	defer w.Unlock()
+	err = w.rotate()
+	if err != nil {
+		return
+	}
+	responseID := newRecordID()
+	responseHeaders := []warcHeader{
+		{"WARC-Type", "response"},
+		{"WARC-Record-ID", responseID},
+		{"WARC-Date", date.UTC().Format(warcDate)},
+		{"WARC-Target-URI", targetURI},
+		{"WARC-Warcinfo-ID", w.warcinfo},
+		{"WARC-Payload-Digest", digest(payload)},
+		{"Content-Type", "application/http;msgtype=response"},
+	}
+	if truncated {
+		responseHeaders = append(responseHeaders, warcHeader{"WARC-Truncated", "length"})
+	}
+	err = w.writeRecord(responseHeaders, response)
+	if err != nil {
+		return
+	}
+	return w.writeRecord([]warcHeader{
+		{"WARC-Type", "request"},
+		{"WARC-Record-ID", newRecordID()},
+		{"WARC-Date", date.UTC().Format(warcDate)},
+		{"WARC-Target-URI", targetURI},
+		{"WARC-Warcinfo-ID", w.warcinfo},
+		{"WARC-Concurrent-To", responseID},
+		{"Content-Type", "application/http;msgtype=request"},
+	}, request)
+}
+
+// rotate starts a new file, beginning with a warcinfo record, if there is
+// none yet or the current one is full
+func (w *warcWriter) rotate() (err error) {
+	if w.f != nil && (w.maxSize <= 0 || w.size < w.maxSize) {
+		return
+	}
+	if w.f != nil {
+		err = w.f.Close()
+		if err != nil {
+			return
+		}
+	}
+	w.files++
+	w.name = fmt.Sprintf("%s-%s-%05d.warc.gz", w.prefix, w.startedAt, w.files)
+	w.f, err = os.OpenFile(filepath.Join(w.dir, w.name), os.O_CREATE|os.O_EXCL|os.O_WRONLY, 0644)
+	if err != nil {
+		err = errors.Wrap(err, "could not create WARC file")
+		return
+	}
+	w.size = 0
+	log.Infof("writing WARC records to %s", w.name)
+
+	hostname, _ := os.Hostname()
+	info := fmt.Sprintf("software: crawdad\r\nformat: WARC File Format 1.1\r\nconformsTo: http://iipc.github.io/warc-specifications/specifications/warc-format/warc-1.1/\r\nhostname: %s\r\n", hostname)
+	w.warcinfo = newRecordID()
+	return w.writeRecord([]warcHeader{
+		{"WARC-Type", "warcinfo"},
+		{"WARC-Record-ID", w.warcinfo},
+		{"WARC-Date", time.Now().UTC().Format(warcDate)},
+		{"WARC-Filename", w.name},
+		{"Content-Type", "application/warc-fields"},
+	}, []byte(info))
+}
+
+// writeRecord writes a record as a gzip member
+func (w *warcWriter) writeRecord(headers []warcHeader, block []byte) (err error) {
+	var record bytes.Buffer
+	record.WriteString("WARC/1.1\r\n")
+	for _, h := range headers {
+		record.WriteString(h.name + ": " + h.value + "\r\n")
+	}
+	record.WriteString("WARC-Block-Digest: " + digest(block) + "\r\n")
+	record.WriteString("Content-Length: " + strconv.Itoa(len(block)) + "\r\n\r\n")
+	record.Write(block)
+	record.WriteString("\r\n\r\n")
+
+	var compressed bytes.Buffer
+	gz := gzip.NewWriter(&compressed)
+	gz.Write(record.Bytes())
+	gz.Close()
+	n, err := w.f.Write(compressed.Bytes())
+	w.size += int64(n)
+	if err != nil {
+		err = errors.Wrap(err, "could not write WARC record")
+	}
+	return
+}
+
+func (w *warcWriter) close() (err error) {
+	w.Lock()
+	defer w.Unlock()
+	if w.f == nil {
+		return
+	}
+	err = w.f.Close()
+	w.f = nil
+	return
+}
+
+// newRecordID returns a random UUID URN
+func newRecordID() string {
+	b := make([]byte, 16)
+	rand.Read(b)
+	b[6] = (b[6] & 0x0f) | 0x40
+	b[8] = (b[8] & 0x3f) | 0x80
+	return fmt.Sprintf("<urn:uuid:%x-%x-%x-%x-%x>", b[0:4], b[4:6], b[6:8], b[8:10], b[10:])
+}
+
+// digest is the base32 SHA-1 of a block, as WARC digests usually are
+func digest(b []byte) string {
+	sum := sha1.Sum(b)
+	return "sha1:" + base32.StdEncoding.EncodeToString(sum[:])
+}
+
+// rawRequest is a request as it is sent
+func rawRequest(method, target string, header map[string]string) []byte {
+	var b bytes.Buffer
+	path := target
+	host := ""
+	if req, err := http.NewRequest(method, target, nil); err == nil {
+		path = req.URL.RequestURI()
+		host = req.URL.Host
+	}
+	fmt.Fprintf(&b, "%s %s HTTP/1.1\r\nHost: %s\r\n", method, path, host)
+	names := make([]string, 0, len(header))
+	for name := range header {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		fmt.Fprintf(&b, "%s: %s\r\n", name, header[name])
+	}
+	b.WriteString("\r\n")
+	return b.Bytes()
+}
+
+// ExportWARC writes the archived responses of the done URLs to WARC files in
+// dir, with request records made from the saved request headers
+func (c *Crawler) ExportWARC(dir string, maxSize int64) (err error) {
+	w, err := newWARCWriter(dir, "crawdad-export", maxSize)
+	if err != nil {
+		return
+	}
+	defer w.close()
+	var numExported, numNotArchived int
+	iter := c.done.Scan(0, "*", 1000).Iterator()
+	for iter.Next() {
+		var val string
+		val, err = c.done.Get(iter.Val()).Result()
+		if err != nil {
+			return
+		}
+		var record Record
+		if json.Unmarshal([]byte(val), &record) != nil || record.ArchivePath == "" {
+			numNotArchived++
+			continue
+		}
+		var response []byte
+		response, err = loadResponse(record)
+		if err != nil {
+			log.Warn(errors.Wrap(err, "could not read archive of "+record.URL))
+			numNotArchived++
+			continue
+		}
+		var payload []byte
+		_, _, payload, err = parseArchive(response)
+		if err != nil {
+			log.Warn(errors.Wrap(err, "could not read archive of "+record.URL))
+			numNotArchived++
+			continue
+		}
+		target := finalURL(record)
+		err = w.writeExchange(target, record.FetchedAt, rawRequest("GET", target, record.RequestHeaders), response, payload, record.Truncated)
+		if err != nil {
+			return
+		}
+		numExported++
+	}
+	err = iter.Err()
+	log.Infof("exported %d URLs to WARC files in %s, %d were not archived", numExported, dir, numNotArchived)
+	return
+}
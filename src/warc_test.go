@@ -0,0 +1,94 @@
+package crawdad
+
+import (
+	"compress/gzip"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// readWARCs returns the records of all the WARC files in a directory
+func readWARCs(t *testing.T, dir string) (records []string) {
+	files, err := filepath.Glob(filepath.Join(dir, "*.warc.gz"))
+	assert.Nil(t, err)
+	for _, name := range files {
+		f, err := os.Open(name)
+		assert.Nil(t, err)
+		gz, err := gzip.NewReader(f)
+		assert.Nil(t, err)
+		b, err := ioutil.ReadAll(gz)
+		assert.Nil(t, err)
+		f.Close()
+		for _, record := range strings.Split(string(b), "\r\n\r\nWARC/1.1\r\n") {
+			records = append(records, record)
+		}
+	}
+	return
+}
+
+func TestWARC(t *testing.T) {
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		fmt.Fprint(w, `<html><a href="/about">about</a></html>`)
+	}))
+	defer ts.Close()
+	dir, err := ioutil.TempDir("", "crawdad")
+	assert.Nil(t, err)
+	defer os.RemoveAll(dir)
+
+	crawl, err := New()
+	assert.Nil(t, err)
+	crawl.RedisPort = "6377"
+	crawl.WARCDir = filepath.Join(dir, "warc")
+	crawl.WARCMaxSize = 1
+	crawl.ArchiveDir = filepath.Join(dir, "archive")
+	err = crawl.Init(Settings{BaseURL: ts.URL})
+	assert.Nil(t, err)
+	assert.Nil(t, crawl.Flush())
+
+	_, record, err := crawl.scrapeLinks(ts.URL + "/")
+	assert.Nil(t, err)
+	_, _, err = crawl.scrapeLinks(ts.URL + "/about")
+	assert.Nil(t, err)
+	crawl.stopCrawling()
+
+	// every exchange went to a new file, which starts with a warcinfo
+	files, _ := filepath.Glob(filepath.Join(crawl.WARCDir, "*.warc.gz"))
+	assert.Equal(t, 2, len(files))
+	records := readWARCs(t, crawl.WARCDir)
+	assert.Equal(t, 6, len(records))
+	assert.Contains(t, records[0], "WARC-Type: warcinfo")
+	assert.Contains(t, records[1], "WARC-Type: response")
+	assert.Contains(t, records[1], "WARC-Target-URI: "+ts.URL+"/\r\n")
+	assert.Contains(t, records[1], "WARC-Payload-Digest: "+digest([]byte(`<html><a href="/about">about</a></html>`)))
+	assert.Contains(t, records[1], "HTTP/1.1 200 OK\r\n")
+	assert.Contains(t, records[2], "WARC-Type: request")
+	assert.Contains(t, records[2], "GET / HTTP/1.1\r\n")
+	responseID := strings.SplitN(strings.SplitN(records[1], "WARC-Record-ID: ", 2)[1], "\r\n", 2)[0]
+	assert.Contains(t, records[2], "WARC-Concurrent-To: "+responseID)
+
+	// the archive can be exported again
+	bRecord := fmt.Sprintf(`{"url":%q,"archive_path":%q,"archive_head":%q,"fetched_at":"2019-05-12T16:03:11Z"}`, record.URL, record.ArchivePath, record.ArchiveHead)
+	assert.Nil(t, crawl.done.Set(record.URL, bRecord, 0).Err())
+	exportDir := filepath.Join(dir, "export")
+	assert.Nil(t, crawl.ExportWARC(exportDir, 0))
+	records = readWARCs(t, exportDir)
+	assert.Equal(t, 3, len(records))
+	assert.Contains(t, records[1], "WARC-Date: 2019-05-12T16:03:11Z")
+	assert.Contains(t, records[1], `<html><a href="/about">about</a></html>`)
+
+	// archives that can not be read are skipped
+	corruptPath, err := crawl.archive([]byte("not a response"))
+	assert.Nil(t, err)
+	bRecord = fmt.Sprintf(`{"url":"https://example.com/corrupt","archive_path":%q}`, corruptPath)
+	assert.Nil(t, crawl.done.Set("https://example.com/corrupt", bRecord, 0).Err())
+	assert.Nil(t, crawl.ExportWARC(filepath.Join(dir, "skipped"), 0))
+	assert.Equal(t, 3, len(readWARCs(t, filepath.Join(dir, "skipped"))))
+	assert.Nil(t, crawl.Flush())
+}